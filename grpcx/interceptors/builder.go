@@ -16,6 +16,11 @@ func (b *Builder) PeerName(ctx context.Context) string {
 	return b.grpcHeaderValue(ctx, "app")
 }
 
+// RequestID 获取客户端传过来的 x-request-id
+func (b *Builder) RequestID(ctx context.Context) string {
+	return b.grpcHeaderValue(ctx, "x-request-id")
+}
+
 // PeerIP 获取对端ip
 func (b *Builder) PeerIP(ctx context.Context) string {
 	// 如果在 ctx 里面传入。或者说客户端里面设置了，就直接用它设置的
@@ -18,7 +18,13 @@ type InterceptorBuilder struct {
 }
 
 func NewInterceptorBuilder(l logger.Logger) *InterceptorBuilder {
-	return &InterceptorBuilder{l: l}
+	return &InterceptorBuilder{l: l.Named("grpc")}
+}
+
+// Sample 高 QPS 下每个 interval 只输出前 first 条 RPC 日志，之后每 thereafter 条输出一条
+func (b *InterceptorBuilder) Sample(interval time.Duration, first, thereafter uint64) *InterceptorBuilder {
+	b.l = logger.NewSamplingLogger(b.l, interval, first, thereafter)
+	return b
 }
 
 func (b *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInterceptor {
@@ -28,6 +34,17 @@ func (b *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInter
 	) (resp any, err error) {
 		start := time.Now()
 		event := "normal"
+		// 放入 ctx，业务代码里面用 XxxCtx 打印的日志也会带上这些字段
+		ctxFields := []logger.Field{
+			logger.String("method", info.FullMethod),
+			// 客户端的信息
+			logger.String("peer", b.PeerName(ctx)),
+			logger.String("peer_ip", b.PeerIP(ctx)),
+		}
+		if requestID := b.RequestID(ctx); requestID != "" {
+			ctxFields = append(ctxFields, logger.String("request_id", requestID))
+		}
+		ctx = logger.WithContext(ctx, ctxFields...)
 		defer func() {
 			// 最终输出日志
 			cost := time.Since(start)
@@ -51,10 +68,6 @@ func (b *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInter
 				logger.String("type", "unary"),
 				logger.Int64("cost", cost.Milliseconds()),
 				logger.String("event", event),
-				logger.String("method", info.FullMethod),
-				// 客户端的信息
-				logger.String("peer", b.PeerName(ctx)),
-				logger.String("peer_ip", b.PeerIP(ctx)),
 			}
 			st, _ := status.FromError(err)
 			if st != nil {
@@ -63,7 +76,7 @@ func (b *InterceptorBuilder) BuildServerUnaryInterceptor() grpc.UnaryServerInter
 				fields = append(fields, logger.String("code_msg", st.Message()))
 			}
 
-			b.l.Info("RPC调用", fields...)
+			b.l.InfoCtx(ctx, "RPC调用", fields...)
 		}()
 		resp, err = handler(ctx, req)
 		return
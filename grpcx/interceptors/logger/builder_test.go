@@ -0,0 +1,92 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/DaHuangQwQ/gpkg/logger/loggertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestInterceptorBuilder_BuildServerUnaryInterceptor(t *testing.T) {
+	testCases := []struct {
+		name     string
+		handler  func(l logger.Logger) grpc.UnaryHandler
+		wantCode codes.Code
+		wantLogs func(t *testing.T, l *loggertest.Logger)
+	}{
+		{
+			name: "panic",
+			handler: func(l logger.Logger) grpc.UnaryHandler {
+				return func(ctx context.Context, req any) (any, error) {
+					panic("mock panic")
+				}
+			},
+			wantCode: codes.Internal,
+			wantLogs: func(t *testing.T, l *loggertest.Logger) {
+				l.AssertLogged(t, logger.InfoLevel, "RPC调用",
+					logger.String("event", "recover"),
+					logger.String("code", codes.Internal.String()),
+					logger.String("code_msg", "panic, err mock panic"),
+					logger.String("method", "/user.UserService/Get"),
+					logger.String("request_id", "req-1"))
+			},
+		},
+		{
+			name: "业务错误",
+			handler: func(l logger.Logger) grpc.UnaryHandler {
+				return func(ctx context.Context, req any) (any, error) {
+					return nil, status.Error(codes.NotFound, "user not found")
+				}
+			},
+			wantCode: codes.NotFound,
+			wantLogs: func(t *testing.T, l *loggertest.Logger) {
+				l.AssertLogged(t, logger.InfoLevel, "RPC调用",
+					logger.String("event", "normal"),
+					logger.String("code", codes.NotFound.String()),
+					logger.String("code_msg", "user not found"))
+			},
+		},
+		{
+			name: "业务日志带上 ctx 中的字段",
+			handler: func(l logger.Logger) grpc.UnaryHandler {
+				return func(ctx context.Context, req any) (any, error) {
+					err := errors.New("mock error")
+					l.ErrorCtx(ctx, "查询用户失败", logger.Error(err))
+					return nil, err
+				}
+			},
+			wantCode: codes.Unknown,
+			wantLogs: func(t *testing.T, l *loggertest.Logger) {
+				entries := l.FilterMessage("查询用户失败")
+				require.Len(t, entries, 1)
+				assert.Equal(t, "", entries[0].Name)
+				assert.True(t, entries[0].Has(
+					logger.String("method", "/user.UserService/Get"),
+					logger.String("peer", "user-web"),
+					logger.String("request_id", "req-1")))
+				entries = l.FilterMessage("RPC调用")
+				require.Len(t, entries, 1)
+				assert.Equal(t, "grpc", entries[0].Name)
+				assert.True(t, entries[0].Has(logger.String("type", "unary")))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := loggertest.NewLogger()
+			interceptor := NewInterceptorBuilder(l).BuildServerUnaryInterceptor()
+			ctx := metadata.NewIncomingContext(context.Background(),
+				metadata.Pairs("x-request-id", "req-1", "app", "user-web"))
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"}, tc.handler(l))
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			tc.wantLogs(t, l)
+		})
+	}
+}
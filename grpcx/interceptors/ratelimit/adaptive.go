@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	limit "github.com/DaHuangQwQ/gpkg/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdaptiveInterceptorBuilder 要在请求处理完之后通知限流器，例如自适应限流、并发限流
+type AdaptiveInterceptorBuilder struct {
+	limiter limit.Acquirer
+	key     string
+	l       logger.Logger
+}
+
+func NewAdaptiveInterceptorBuilder(limiter limit.Acquirer, key string, l logger.Logger) *AdaptiveInterceptorBuilder {
+	return &AdaptiveInterceptorBuilder{limiter: limiter, key: key,
+		l: l.Named("ratelimit").With(logger.String("key", key))}
+}
+
+func (i *AdaptiveInterceptorBuilder) BuildServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done, err := i.acquire(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		return handler(ctx, req)
+	}
+}
+
+// BuildStreamServerInterceptor 流式调用整个流结束之后才算处理完
+func (i *AdaptiveInterceptorBuilder) BuildStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done, err := i.acquire(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer done()
+		return handler(srv, ss)
+	}
+}
+
+func (i *AdaptiveInterceptorBuilder) acquire(ctx context.Context, method string) (limit.DoneFunc, error) {
+	done, err := i.limiter.Acquire(limit.ContextWithMethod(ctx, method), i.key)
+	if errors.Is(err, limit.ErrLimitExceeded) {
+		return nil, status.Error(codes.ResourceExhausted, "limit")
+	}
+	if err != nil {
+		i.l.ErrorCtx(ctx, "判断限流出现问题", logger.Error(err))
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return done, nil
+}
@@ -2,47 +2,155 @@ package ratelimit
 
 import (
 	"context"
+	"fmt"
 	"github.com/DaHuangQwQ/gpkg/logger"
 	limit "github.com/DaHuangQwQ/gpkg/ratelimit"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"strconv"
+	"time"
+)
+
+// 服务端通过 trailer 把限流的结果告诉客户端
+const (
+	TrailerLimit      = "x-ratelimit-limit"
+	TrailerRemaining  = "x-ratelimit-remaining"
+	TrailerReset      = "x-ratelimit-reset-ms"
+	TrailerRetryAfter = "x-ratelimit-retry-after-ms"
 )
 
 type InterceptorBuilder struct {
 	limiter limit.Limiter
 	key     string
 	l       logger.Logger
+	// 每个方法消耗的配额，key 是 FullMethod，没配置的方法消耗 1 个
+	costs map[string]int64
+	// 计算 TrailerReset 用的时钟，要和限流器用同一个
+	clock limit.Clock
+	// 限流器需要统计在途请求的时候不为 nil，走 Acquire
+	adaptive *AdaptiveInterceptorBuilder
 }
 
+// NewInterceptorBuilder 并发限流、自适应限流应该用 NewAdaptiveInterceptorBuilder
+// 传进来的话只调用 Limit 永远不会限流，所以会改成调用 Acquire，不设置 trailer，也不支持 Costs
 func NewInterceptorBuilder(limiter limit.Limiter, key string, l logger.Logger) *InterceptorBuilder {
-	return &InterceptorBuilder{limiter: limiter, key: key, l: l}
+	l = l.Named("ratelimit").With(logger.String("key", key))
+	res := &InterceptorBuilder{limiter: limiter, key: key, l: l}
+	if al, ok := limiter.(limit.AcquireLimiter); ok {
+		l.Warn("限流器需要统计在途请求，已改用 Acquire，请使用 AdaptiveInterceptorBuilder")
+		res.adaptive = &AdaptiveInterceptorBuilder{limiter: al, key: key, l: l}
+	}
+	return res
+}
+
+// Costs 按方法配置消耗的配额，例如批量导出比单点查询贵得多
+// 配额必须大于 0，否则 panic。限流器要实现 ratelimit.ResultLimiter 才支持大于 1 的配额
+func (i *InterceptorBuilder) Costs(costs map[string]int64) *InterceptorBuilder {
+	for method, cost := range costs {
+		if cost < 1 {
+			panic(fmt.Sprintf("方法 %s 的配额必须大于 0，实际是 %d", method, cost))
+		}
+	}
+	i.costs = costs
+	return i
+}
+
+// Clock 限流器使用了 ratelimit.WithClock 的时候要传入同一个时钟，否则 TrailerReset 不准
+func (i *InterceptorBuilder) Clock(clock limit.Clock) *InterceptorBuilder {
+	i.clock = clock
+	return i
 }
 
 func (i *InterceptorBuilder) BuildServerInterceptor() grpc.UnaryServerInterceptor {
+	if i.adaptive != nil {
+		return i.adaptive.BuildServerInterceptor()
+	}
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		limited, err := i.limiter.Limit(ctx, i.key)
+		res, err := limit.AllowN(limit.ContextWithMethod(ctx, info.FullMethod),
+			i.limiter, i.key, i.cost(info.FullMethod))
 		if err != nil {
-			i.l.Error("判断限流出现问题", logger.Error(err))
+			i.l.ErrorCtx(ctx, "判断限流出现问题", logger.Error(err))
 			return nil, status.Error(codes.ResourceExhausted, err.Error())
 		}
-		if limited {
+		// 设置失败也不影响业务
+		_ = grpc.SetTrailer(ctx, i.trailer(res))
+		if !res.Allowed {
 			return nil, status.Error(codes.ResourceExhausted, "limit")
 		}
 		return handler(ctx, req)
 	}
 }
 
+// BuildStreamServerInterceptor 整个流只在建立的时候判断一次
+func (i *InterceptorBuilder) BuildStreamServerInterceptor() grpc.StreamServerInterceptor {
+	if i.adaptive != nil {
+		return i.adaptive.BuildStreamServerInterceptor()
+	}
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		res, err := limit.AllowN(limit.ContextWithMethod(ctx, info.FullMethod),
+			i.limiter, i.key, i.cost(info.FullMethod))
+		if err != nil {
+			i.l.ErrorCtx(ctx, "判断限流出现问题", logger.Error(err))
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		ss.SetTrailer(i.trailer(res))
+		if !res.Allowed {
+			return status.Error(codes.ResourceExhausted, "limit")
+		}
+		return handler(srv, ss)
+	}
+}
+
 func (i *InterceptorBuilder) BuildClientInterceptor() grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		limited, err := i.limiter.Limit(ctx, i.key)
+		if i.adaptive != nil {
+			done, err := i.adaptive.acquire(ctx, method)
+			if err != nil {
+				return err
+			}
+			defer done()
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		res, err := limit.AllowN(limit.ContextWithMethod(ctx, method),
+			i.limiter, i.key, i.cost(method))
 		if err != nil {
-			i.l.Error("判断限流出现问题", logger.Error(err))
+			i.l.ErrorCtx(ctx, "判断限流出现问题", logger.Error(err))
 			return status.Error(codes.ResourceExhausted, err.Error())
 		}
-		if limited {
+		if !res.Allowed {
 			return status.Error(codes.ResourceExhausted, "limit")
 		}
 		return invoker(ctx, method, req, reply, cc, opts...)
 	}
 }
+
+func (i *InterceptorBuilder) cost(method string) int64 {
+	if cost, ok := i.costs[method]; ok {
+		return cost
+	}
+	return 1
+}
+
+func (i *InterceptorBuilder) now() time.Time {
+	if i.clock == nil {
+		return time.Now()
+	}
+	return i.clock.Now()
+}
+
+func (i *InterceptorBuilder) trailer(res limit.Result) metadata.MD {
+	md := metadata.Pairs(
+		TrailerLimit, strconv.FormatInt(res.Limit, 10),
+		TrailerRemaining, strconv.FormatInt(res.Remaining, 10),
+	)
+	if !res.ResetAt.IsZero() {
+		md.Set(TrailerReset, strconv.FormatInt(max(res.ResetAt.Sub(i.now()).Milliseconds(), 0), 10))
+	}
+	if !res.Allowed {
+		md.Set(TrailerRetryAfter, strconv.FormatInt(res.RetryAfter.Milliseconds(), 10))
+	}
+	return md
+}
@@ -0,0 +1,334 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/DaHuangQwQ/gpkg/logger/loggertest"
+	limit "github.com/DaHuangQwQ/gpkg/ratelimit"
+	limitmocks "github.com/DaHuangQwQ/gpkg/ratelimit/mocks"
+	"github.com/DaHuangQwQ/gpkg/ratelimit/ratelimittest"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestInterceptorBuilder_BuildServerInterceptor(t *testing.T) {
+	redisErr := errors.New("redis error")
+	testCases := []struct {
+		name     string
+		mock     func(ctrl *gomock.Controller) limit.Limiter
+		wantCode codes.Code
+		wantLog  bool
+	}{
+		{
+			name: "判断限流出错",
+			mock: func(ctrl *gomock.Controller) limit.Limiter {
+				limiter := limitmocks.NewMockResultLimiter(ctrl)
+				limiter.EXPECT().AllowN(gomock.Any(), "user", int64(1)).
+					Return(limit.Result{}, redisErr)
+				return limiter
+			},
+			wantCode: codes.ResourceExhausted,
+			wantLog:  true,
+		},
+		{
+			name: "限流",
+			mock: func(ctrl *gomock.Controller) limit.Limiter {
+				limiter := limitmocks.NewMockResultLimiter(ctrl)
+				limiter.EXPECT().AllowN(gomock.Any(), "user", int64(1)).
+					Return(limit.Result{Allowed: false}, nil)
+				return limiter
+			},
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name: "放行",
+			mock: func(ctrl *gomock.Controller) limit.Limiter {
+				limiter := limitmocks.NewMockResultLimiter(ctrl)
+				limiter.EXPECT().AllowN(gomock.Any(), "user", int64(1)).
+					Return(limit.Result{Allowed: true}, nil)
+				return limiter
+			},
+			wantCode: codes.OK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			l := loggertest.NewLogger()
+			interceptor := NewInterceptorBuilder(tc.mock(ctrl), "user", l).BuildServerInterceptor()
+			ctx := logger.WithContext(context.Background(), logger.String("request_id", "req-1"))
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"},
+				func(ctx context.Context, req any) (any, error) {
+					return nil, nil
+				})
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			if !tc.wantLog {
+				assert.Equal(t, 0, l.Len())
+				return
+			}
+			l.AssertLogged(t, logger.ErrorLevel, "判断限流出现问题",
+				logger.String("key", "user"),
+				logger.String("request_id", "req-1"),
+				logger.Error(redisErr))
+			assert.Equal(t, "ratelimit", l.All()[0].Name)
+		})
+	}
+}
+
+func TestInterceptorBuilder_Trailer(t *testing.T) {
+	// 时钟停在过去，用墙上时间算出来的 TrailerReset 会是 0
+	clock := ratelimittest.NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := limit.NewFixWindowLimiter(time.Second, 1, limit.WithClock(clock))
+	interceptor := NewInterceptorBuilder(limiter, "user", loggertest.NewLogger()).
+		Clock(clock).
+		BuildServerInterceptor()
+	clock.Add(400 * time.Millisecond)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	}
+	stream := &trailerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	_, err := interceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.Pairs(
+		TrailerLimit, "1",
+		TrailerRemaining, "0",
+		TrailerReset, "600",
+	), stream.trailer)
+
+	stream = &trailerStream{}
+	ctx = grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, metadata.Pairs(
+		TrailerLimit, "1",
+		TrailerRemaining, "0",
+		TrailerReset, "600",
+		TrailerRetryAfter, "600",
+	), stream.trailer)
+}
+
+func TestInterceptorBuilder_BuildStreamServerInterceptor(t *testing.T) {
+	clock := ratelimittest.NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := limit.NewFixWindowLimiter(time.Second, 1, limit.WithClock(clock))
+	interceptor := NewInterceptorBuilder(limiter, "user", loggertest.NewLogger()).
+		Clock(clock).
+		BuildStreamServerInterceptor()
+	clock.Add(400 * time.Millisecond)
+
+	info := &grpc.StreamServerInfo{FullMethod: "/user.UserService/Watch"}
+	calls := 0
+	handler := func(srv any, ss grpc.ServerStream) error {
+		calls++
+		return nil
+	}
+	ss := &serverStream{ctx: context.Background()}
+	err := interceptor(nil, ss, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.Pairs(
+		TrailerLimit, "1",
+		TrailerRemaining, "0",
+		TrailerReset, "600",
+	), ss.trailer)
+
+	clock.Add(100 * time.Millisecond)
+	ss = &serverStream{ctx: context.Background()}
+	err = interceptor(nil, ss, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, metadata.Pairs(
+		TrailerLimit, "1",
+		TrailerRemaining, "0",
+		TrailerReset, "500",
+		TrailerRetryAfter, "500",
+	), ss.trailer)
+	assert.Equal(t, 1, calls)
+}
+
+// trailerStream 记录一元调用通过 grpc.SetTrailer 设置的 trailer
+type trailerStream struct {
+	trailer metadata.MD
+}
+
+func (s *trailerStream) Method() string {
+	return ""
+}
+
+func (s *trailerStream) SetHeader(md metadata.MD) error {
+	return nil
+}
+
+func (s *trailerStream) SendHeader(md metadata.MD) error {
+	return nil
+}
+
+func (s *trailerStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+// serverStream 记录流式调用设置的 trailer
+type serverStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	trailer metadata.MD
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+func TestInterceptorBuilder_Costs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	limiter := limitmocks.NewMockResultLimiter(ctrl)
+	limiter.EXPECT().AllowN(gomock.Any(), "user", int64(5)).
+		Return(limit.Result{Allowed: true}, nil)
+	interceptor := NewInterceptorBuilder(limiter, "user", loggertest.NewLogger()).
+		Costs(map[string]int64{"/user.UserService/Export": 5}).
+		BuildServerInterceptor()
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Export"},
+		func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+	assert.NoError(t, err)
+
+	// 负数的配额会凭空产生配额
+	assert.PanicsWithValue(t, "方法 /user.UserService/Export 的配额必须大于 0，实际是 -1", func() {
+		NewInterceptorBuilder(limiter, "user", loggertest.NewLogger()).
+			Costs(map[string]int64{"/user.UserService/Export": -1})
+	})
+}
+
+func TestNewInterceptorBuilder_AcquireLimiter(t *testing.T) {
+	l := loggertest.NewLogger()
+	limiter, err := limit.NewConcurrencyLimiter(1)
+	assert.NoError(t, err)
+	builder := NewInterceptorBuilder(limiter, "user", l)
+	l.AssertLogged(t, logger.WarnLevel, "限流器需要统计在途请求，已改用 Acquire，请使用 AdaptiveInterceptorBuilder",
+		logger.String("key", "user"))
+
+	// 只调用 Limit 永远不会限流，所以改成调用 Acquire
+	interceptor := builder.BuildServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"}
+	_, err = interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		_, er := interceptor(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+			t.Fatal("不应该调用业务逻辑")
+			return nil, nil
+		})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(er))
+		return nil, nil
+	})
+	assert.NoError(t, err)
+
+	client := builder.BuildClientInterceptor()
+	err = client(context.Background(), "/user.UserService/Get", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			er := client(ctx, method, nil, nil, nil,
+				func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+					t.Fatal("不应该发起调用")
+					return nil
+				})
+			assert.Equal(t, codes.ResourceExhausted, status.Code(er))
+			return nil
+		})
+	assert.NoError(t, err)
+
+	// 处理完之后释放了信号量
+	_, err = interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestAdaptiveInterceptorBuilder_BuildServerInterceptor(t *testing.T) {
+	redisErr := errors.New("redis error")
+	testCases := []struct {
+		name     string
+		err      error
+		wantCode codes.Code
+		wantLog  bool
+	}{
+		{
+			name:     "判断限流出错",
+			err:      redisErr,
+			wantCode: codes.ResourceExhausted,
+			wantLog:  true,
+		},
+		{
+			// 正常的限流不需要打印日志
+			name:     "限流",
+			err:      limit.ErrLimitExceeded,
+			wantCode: codes.ResourceExhausted,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			limiter := limitmocks.NewMockAcquirer(ctrl)
+			limiter.EXPECT().Acquire(gomock.Any(), "user").Return(nil, tc.err)
+			l := loggertest.NewLogger()
+			interceptor := NewAdaptiveInterceptorBuilder(limiter, "user", l).BuildServerInterceptor()
+			_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/Get"},
+				func(ctx context.Context, req any) (any, error) {
+					t.Fatal("不应该调用业务逻辑")
+					return nil, nil
+				})
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			if !tc.wantLog {
+				l.AssertNotLogged(t, logger.ErrorLevel, "判断限流出现问题")
+				return
+			}
+			l.AssertLogged(t, logger.ErrorLevel, "判断限流出现问题",
+				logger.String("key", "user"),
+				logger.Error(redisErr))
+		})
+	}
+}
+
+func TestAdaptiveInterceptorBuilder_BuildStreamServerInterceptor(t *testing.T) {
+	limiter, err := limit.NewConcurrencyLimiter(1)
+	assert.NoError(t, err)
+	interceptor := NewAdaptiveInterceptorBuilder(limiter, "user", loggertest.NewLogger()).
+		BuildStreamServerInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: "/user.UserService/Watch"}
+	newStream := func() grpc.ServerStream {
+		return &serverStream{ctx: context.Background()}
+	}
+
+	err = interceptor(nil, newStream(), info, func(srv any, ss grpc.ServerStream) error {
+		// 流还没结束，信号量一直被占用
+		limited, er := limiter.Limit(ss.Context(), "user")
+		assert.True(t, limited)
+		assert.ErrorIs(t, er, limit.ErrLimitExceeded)
+		er = interceptor(nil, newStream(), info, func(srv any, ss grpc.ServerStream) error {
+			t.Fatal("不应该调用业务逻辑")
+			return nil
+		})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(er))
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// 流结束之后释放了信号量
+	calls := 0
+	err = interceptor(nil, newStream(), info, func(srv any, ss grpc.ServerStream) error {
+		calls++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+}
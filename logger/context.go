@@ -0,0 +1,108 @@
+package logger
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+)
+
+// Extractor 从 ctx 里面提取需要打印的字段，例如 trace id、request id
+type Extractor func(ctx context.Context) []Field
+
+type namedExtractor struct {
+	name string
+	fn   Extractor
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   = []namedExtractor{
+		{name: "fields", fn: fieldsExtractor},
+		{name: "trace", fn: traceExtractor},
+	}
+)
+
+// RegisterExtractor 注册一个 Extractor，同名的会被覆盖
+// 所有 XxxCtx 方法都会调用已注册的 Extractor 来补充字段
+func RegisterExtractor(name string, fn Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	for i, e := range extractors {
+		if e.name == name {
+			extractors[i].fn = fn
+			return
+		}
+	}
+	extractors = append(extractors, namedExtractor{name: name, fn: fn})
+}
+
+// UnregisterExtractor 移除 Extractor，包括默认的 fields 和 trace
+func UnregisterExtractor(name string) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	for i, e := range extractors {
+		if e.name == name {
+			extractors = append(extractors[:i:i], extractors[i+1:]...)
+			return
+		}
+	}
+}
+
+// Extract 按照注册顺序调用所有的 Extractor
+func Extract(ctx context.Context) []Field {
+	if ctx == nil {
+		return nil
+	}
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	var res []Field
+	for _, e := range extractors {
+		res = append(res, e.fn(ctx)...)
+	}
+	return res
+}
+
+type fieldsKey struct{}
+
+// WithContext 把字段放进 ctx，后续使用这个 ctx 打印的日志都会带上这些字段
+// 例如 grpcx 的日志拦截器会放入 method、peer、request_id
+func WithContext(ctx context.Context, fields ...Field) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+	old := FieldsFromContext(ctx)
+	res := make([]Field, 0, len(old)+len(fields))
+	res = append(res, old...)
+	res = append(res, fields...)
+	return context.WithValue(ctx, fieldsKey{}, res)
+}
+
+// FieldsFromContext 获得 WithContext 放入的字段
+func FieldsFromContext(ctx context.Context) []Field {
+	fields, _ := ctx.Value(fieldsKey{}).([]Field)
+	return fields
+}
+
+func fieldsExtractor(ctx context.Context) []Field {
+	return FieldsFromContext(ctx)
+}
+
+func traceExtractor(ctx context.Context) []Field {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []Field{
+		String("trace_id", sc.TraceID().String()),
+		String("span_id", sc.SpanID().String()),
+	}
+}
+
+// withContext 把 ctx 中提取出来的字段放在前面
+func withContext(ctx context.Context, args []Field) []Field {
+	fields := Extract(ctx)
+	if len(fields) == 0 {
+		return args
+	}
+	return append(fields, args...)
+}
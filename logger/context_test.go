@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+)
+
+func TestZapLogger_Ctx(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	l := NewZapLogger(zap.New(core))
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+	ctx = WithContext(ctx, String("request_id", "req-1"))
+	ctx = WithContext(ctx, String("method", "/user/Get"))
+
+	RegisterExtractor("tenant", func(ctx context.Context) []Field {
+		return []Field{String("tenant", "t1")}
+	})
+	defer UnregisterExtractor("tenant")
+
+	l.InfoCtx(ctx, "hello", Int64("uid", 123))
+	l.Info("no ctx")
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, map[string]any{
+		"request_id": "req-1",
+		"method":     "/user/Get",
+		"trace_id":   "4bf92f3577b34da6a3ce929d0e0e4736",
+		"span_id":    "00f067aa0ba902b7",
+		"tenant":     "t1",
+		"uid":        int64(123),
+	}, entries[0].ContextMap())
+	assert.Empty(t, entries[1].ContextMap())
+
+	UnregisterExtractor("tenant")
+	l.WarnCtx(context.Background(), "empty")
+	assert.Empty(t, logs.AllUntimed()[2].ContextMap())
+}
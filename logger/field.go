@@ -0,0 +1,198 @@
+package logger
+
+import (
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"math"
+	"reflect"
+	"time"
+)
+
+// FieldType 决定了 Field 的值存放在哪里
+// 基本类型放在 Integer 和 Str 里面，避免装箱成 any 的时候分配内存
+type FieldType uint8
+
+const (
+	// AnyType 值放在 Val 里面，直接构造 Field{Key: key, Val: val} 就是这个类型
+	AnyType FieldType = iota
+	StringType
+	Int64Type
+	Uint64Type
+	BoolType
+	Float64Type
+	DurationType
+	// TimeType Integer 是 UnixNano，Val 是 *time.Location
+	TimeType
+	// TimeFullType 超出 UnixNano 表示范围的时间，Val 是 time.Time
+	TimeFullType
+	BytesType
+	StringerType
+	ErrorType
+	ObjectType
+	ArrayType
+	GroupType
+	LazyType
+)
+
+type Field struct {
+	Key     string
+	Type    FieldType
+	Integer int64
+	Str     string
+	Val     any
+}
+
+// Value 返回字段的值，给不认识 FieldType 的实现使用
+// ObjectType 和 ArrayType 会被展开成 map[string]any 和 []any
+// 值是 nil 的时候返回 nil，StringerType 返回 "<nil>"
+func (f Field) Value() any {
+	switch f.Type {
+	case StringType:
+		return f.Str
+	case Int64Type:
+		return f.Integer
+	case Uint64Type:
+		return uint64(f.Integer)
+	case BoolType:
+		return f.Integer == 1
+	case Float64Type:
+		return math.Float64frombits(uint64(f.Integer))
+	case DurationType:
+		return time.Duration(f.Integer)
+	case TimeType:
+		return time.Unix(0, f.Integer).In(f.Val.(*time.Location))
+	case StringerType:
+		s, ok := f.Val.(fmt.Stringer)
+		if !ok {
+			return "<nil>"
+		}
+		return stringify(s)
+	case ObjectType:
+		obj, ok := f.Val.(zapcore.ObjectMarshaler)
+		if !ok {
+			return nil
+		}
+		enc := zapcore.NewMapObjectEncoder()
+		_ = enc.AddObject(f.Key, obj)
+		return enc.Fields[f.Key]
+	case ArrayType:
+		arr, ok := f.Val.(zapcore.ArrayMarshaler)
+		if !ok {
+			return nil
+		}
+		enc := zapcore.NewMapObjectEncoder()
+		_ = enc.AddArray(f.Key, arr)
+		return enc.Fields[f.Key]
+	case LazyType:
+		fn, ok := f.Val.(func() any)
+		if !ok || fn == nil {
+			return nil
+		}
+		return fn()
+	default:
+		// AnyType、TimeFullType、BytesType、ErrorType、GroupType
+		return f.Val
+	}
+}
+
+func String(key string, val string) Field {
+	return Field{Key: key, Type: StringType, Str: val}
+}
+
+func Int(key string, val int) Field {
+	return Int64(key, int64(val))
+}
+
+func Int32(key string, val int32) Field {
+	return Int64(key, int64(val))
+}
+
+func Int64(key string, val int64) Field {
+	return Field{Key: key, Type: Int64Type, Integer: val}
+}
+
+func Uint64(key string, val uint64) Field {
+	return Field{Key: key, Type: Uint64Type, Integer: int64(val)}
+}
+
+func Bool(key string, val bool) Field {
+	var i int64
+	if val {
+		i = 1
+	}
+	return Field{Key: key, Type: BoolType, Integer: i}
+}
+
+func Float64(key string, val float64) Field {
+	return Field{Key: key, Type: Float64Type, Integer: int64(math.Float64bits(val))}
+}
+
+func Duration(key string, val time.Duration) Field {
+	return Field{Key: key, Type: DurationType, Integer: int64(val)}
+}
+
+// minTime 和 maxTime 是 UnixNano 能够表示的范围
+var (
+	minTime = time.Unix(0, math.MinInt64)
+	maxTime = time.Unix(0, math.MaxInt64)
+)
+
+func Time(key string, val time.Time) Field {
+	if val.Before(minTime) || val.After(maxTime) {
+		return Field{Key: key, Type: TimeFullType, Val: val}
+	}
+	return Field{Key: key, Type: TimeType, Integer: val.UnixNano(), Val: val.Location()}
+}
+
+func Bytes(key string, val []byte) Field {
+	return Field{Key: key, Type: BytesType, Val: val}
+}
+
+// Stringer 只有真的输出日志的时候才会调用 String 方法
+func Stringer(key string, val fmt.Stringer) Field {
+	return Field{Key: key, Type: StringerType, Val: val}
+}
+
+func Object(key string, val zapcore.ObjectMarshaler) Field {
+	return Field{Key: key, Type: ObjectType, Val: val}
+}
+
+func Array(key string, val zapcore.ArrayMarshaler) Field {
+	return Field{Key: key, Type: ArrayType, Val: val}
+}
+
+// Lazy 只有真的输出日志的时候才会调用 fn，适合计算开销比较大的字段
+func Lazy(key string, fn func() any) Field {
+	return Field{Key: key, Type: LazyType, Val: fn}
+}
+
+func Any(key string, val any) Field {
+	return Field{Key: key, Val: val}
+}
+
+// Group 把多个字段组合在 key 下面
+func Group(key string, fields ...Field) Field {
+	return Field{Key: key, Type: GroupType, Val: fields}
+}
+
+func Error(err error) Field {
+	return NamedError("error", err)
+}
+
+func NamedError(key string, err error) Field {
+	return Field{Key: key, Type: ErrorType, Val: err}
+}
+
+// stringify String 方法 panic 的时候，和 zap 一样输出 panic 的信息，nil 指针输出 "<nil>"
+func stringify(s fmt.Stringer) (res string) {
+	defer func() {
+		if err := recover(); err != nil {
+			if v := reflect.ValueOf(s); v.Kind() == reflect.Pointer && v.IsNil() {
+				res = "<nil>"
+				return
+			}
+			res = fmt.Sprintf("PANIC=%v", err)
+		}
+	}()
+	return s.String()
+}
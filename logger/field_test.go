@@ -0,0 +1,204 @@
+package logger
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"io"
+	"testing"
+	"time"
+)
+
+type user struct {
+	ID   int64
+	Name string
+}
+
+func (u user) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt64("id", u.ID)
+	enc.AddString("name", u.Name)
+	return nil
+}
+
+func (u user) String() string {
+	return u.Name
+}
+
+type users []user
+
+func (us users) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, u := range us {
+		_ = enc.AppendObject(u)
+	}
+	return nil
+}
+
+func TestField_Value(t *testing.T) {
+	now := time.UnixMilli(1700000000000).UTC()
+	err := errors.New("mock error")
+	testCases := []struct {
+		field Field
+		want  any
+	}{
+		{field: String("k", "v"), want: "v"},
+		{field: Int("k", 1), want: int64(1)},
+		{field: Int32("k", 2), want: int64(2)},
+		{field: Int64("k", 3), want: int64(3)},
+		{field: Uint64("k", 4), want: uint64(4)},
+		{field: Bool("k", true), want: true},
+		{field: Bool("k", false), want: false},
+		{field: Float64("k", 1.5), want: 1.5},
+		{field: Duration("k", time.Second), want: time.Second},
+		{field: Time("k", now), want: now},
+		{field: Bytes("k", []byte("abc")), want: []byte("abc")},
+		{field: Stringer("k", user{Name: "Tom"}), want: "Tom"},
+		{field: Error(err), want: err},
+		{field: Object("k", user{ID: 1, Name: "Tom"}), want: map[string]any{"id": int64(1), "name": "Tom"}},
+		{field: Array("k", users{{ID: 1, Name: "Tom"}}), want: []any{map[string]any{"id": int64(1), "name": "Tom"}}},
+		{field: Lazy("k", func() any { return 123 }), want: 123},
+		{field: Any("k", []int{1}), want: []int{1}},
+		{field: Field{Key: "k", Val: "v"}, want: "v"},
+		// nil 不会 panic
+		{field: Stringer("k", nil), want: "<nil>"},
+		{field: Stringer("k", (*user)(nil)), want: "<nil>"},
+		{field: Object("k", nil), want: nil},
+		{field: Array("k", nil), want: nil},
+		{field: Lazy("k", nil), want: nil},
+		{field: Error(nil), want: nil},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, tc.field.Value())
+	}
+}
+
+func TestZapLogger_Fields(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	l := NewZapLogger(zap.New(core))
+	now := time.UnixMilli(1700000000000).UTC()
+	l.Info("fields",
+		String("string", "v"),
+		Int64("int64", 1),
+		Uint64("uint64", 2),
+		Bool("bool", true),
+		Float64("float64", 1.5),
+		Duration("duration", time.Second),
+		Time("time", now),
+		Bytes("bytes", []byte("abc")),
+		Stringer("stringer", user{Name: "Tom"}),
+		Object("object", user{ID: 1, Name: "Tom"}),
+		Array("array", users{{ID: 2, Name: "Jerry"}}),
+		Group("group", Int("a", 1)),
+		Lazy("lazy", func() any { return "lazy" }),
+		Error(nil),
+		Field{Key: "any", Val: []int{1}},
+	)
+
+	l.Info("nil",
+		Stringer("stringer", nil),
+		Stringer("stringer_ptr", (*user)(nil)),
+		Object("object", nil),
+		Array("array", nil),
+		Lazy("lazy", nil),
+		Error(nil),
+	)
+
+	called := false
+	l.Debug("disabled", Lazy("lazy", func() any {
+		called = true
+		return "lazy"
+	}))
+	assert.False(t, called)
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, map[string]any{
+		"string":   "v",
+		"int64":    int64(1),
+		"uint64":   uint64(2),
+		"bool":     true,
+		"float64":  1.5,
+		"duration": time.Second,
+		"time":     now,
+		"bytes":    []byte("abc"),
+		"stringer": "Tom",
+		"object":   map[string]any{"id": int64(1), "name": "Tom"},
+		"array":    []any{map[string]any{"id": int64(2), "name": "Jerry"}},
+		"group":    map[string]any{"a": int64(1)},
+		"lazy":     "lazy",
+		"any":      []any{1},
+	}, entries[0].ContextMap())
+	assert.Equal(t, map[string]any{
+		"stringer":     "<nil>",
+		"stringer_ptr": "<nil>",
+		"object":       nil,
+		"array":        nil,
+		"lazy":         nil,
+	}, entries[1].ContextMap())
+}
+
+func newBenchmarkLogger() *ZapLogger {
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return NewZapLogger(zap.New(zapcore.NewCore(enc, zapcore.AddSync(io.Discard), zap.InfoLevel)))
+}
+
+// BenchmarkZapLogger_Fields 对比类型化的字段和原本 Field{Key, Val} 走 zap.Any 的开销
+func BenchmarkZapLogger_Fields(b *testing.B) {
+	now := time.Now()
+	err := errors.New("mock error")
+	b.Run("typed", func(b *testing.B) {
+		l := newBenchmarkLogger()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Info("msg",
+				String("topic", "user"),
+				Int64("offset", 12345),
+				Bool("retry", true),
+				Float64("ratio", 0.5),
+				Duration("cost", time.Millisecond),
+				Time("time", now),
+				Error(err))
+		}
+	})
+	b.Run("any", func(b *testing.B) {
+		l := newBenchmarkLogger()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Info("msg",
+				Field{Key: "topic", Val: "user"},
+				Field{Key: "offset", Val: int64(12345)},
+				Field{Key: "retry", Val: true},
+				Field{Key: "ratio", Val: 0.5},
+				Field{Key: "cost", Val: time.Millisecond},
+				Field{Key: "time", Val: now},
+				Field{Key: "error", Val: err})
+		}
+	})
+}
+
+func BenchmarkZapLogger_Disabled(b *testing.B) {
+	b.Run("typed", func(b *testing.B) {
+		l := newBenchmarkLogger()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Debug("msg", String("topic", "user"), Int64("offset", int64(i)))
+		}
+	})
+	b.Run("lazy", func(b *testing.B) {
+		l := newBenchmarkLogger()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Debug("msg", Lazy("expensive", func() any {
+				return make([]byte, 1024)
+			}))
+		}
+	})
+	b.Run("any", func(b *testing.B) {
+		l := newBenchmarkLogger()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Debug("msg", Field{Key: "topic", Val: "user"}, Field{Key: "offset", Val: int64(i)})
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"fmt"
+	"go.uber.org/zap/zapcore"
+)
+
+// Level 日志级别，取值和 zap 保持一致
+type Level int8
+
+const (
+	DebugLevel Level = iota - 1
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	return zapcore.Level(l).String()
+}
+
+// Enabled 级别为 l 的 logger 是否会输出 lvl 级别的日志
+func (l Level) Enabled(lvl Level) bool {
+	return lvl >= l
+}
+
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	lvl, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = lvl
+	return nil
+}
+
+// ParseLevel 支持 debug、info、warn、error，不区分大小写
+func ParseLevel(text string) (Level, error) {
+	lvl, err := zapcore.ParseLevel(text)
+	if err != nil {
+		return InfoLevel, err
+	}
+	if lvl < zapcore.DebugLevel || lvl > zapcore.ErrorLevel {
+		return InfoLevel, fmt.Errorf("logger: 不支持的日志级别 %s", text)
+	}
+	return Level(lvl), nil
+}
+
+// LevelEnabler 决定某个级别的日志要不要输出
+// Level 本身就是一个 LevelEnabler，LevelRegistry 返回的则可以在运行时修改
+type LevelEnabler interface {
+	Enabled(lvl Level) bool
+}
+
+// levelCore 覆盖 core 的日志级别，可以比原本的级别更低，也可以更高
+// 注意如果 core 是 zapcore.NewTee 组合出来的，那么所有的子 core 都会写入
+type levelCore struct {
+	zapcore.Core
+	level LevelEnabler
+}
+
+func newLevelCore(core zapcore.Core, level LevelEnabler) zapcore.Core {
+	// 避免多次覆盖之后层层嵌套
+	if lc, ok := core.(*levelCore); ok {
+		core = lc.Core
+	}
+	return &levelCore{Core: core, level: level}
+}
+
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return c.level.Enabled(Level(lvl))
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
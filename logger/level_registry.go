@@ -0,0 +1,175 @@
+package logger
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// LevelRegistry 按照名字管理日志级别，可以在运行时修改
+// 没有单独设置的 logger 使用最近的父 logger 的级别，例如 saramax.retry 会使用 saramax 的级别
+// 都没有设置的时候使用 root 的级别
+type LevelRegistry struct {
+	mutex     sync.RWMutex
+	root      Level
+	overrides map[string]Level
+	// 所有创建过的 logger
+	enablers map[string]*levelEnabler
+}
+
+func NewLevelRegistry(root Level) *LevelRegistry {
+	return &LevelRegistry{
+		root:      root,
+		overrides: make(map[string]Level),
+		enablers:  make(map[string]*levelEnabler),
+	}
+}
+
+// SetLevel name 为空的时候修改 root 的级别
+func (r *LevelRegistry) SetLevel(name string, level Level) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if name == "" {
+		r.root = level
+	} else {
+		r.overrides[name] = level
+	}
+	r.refresh()
+}
+
+// UnsetLevel 恢复成使用父 logger 的级别
+func (r *LevelRegistry) UnsetLevel(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.overrides, name)
+	r.refresh()
+}
+
+// Level 返回 name 实际生效的级别
+func (r *LevelRegistry) Level(name string) Level {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.effective(name)
+}
+
+func (r *LevelRegistry) enabler(name string) *levelEnabler {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	e, ok := r.enablers[name]
+	if !ok {
+		e = &levelEnabler{}
+		e.level.Store(int32(r.effective(name)))
+		r.enablers[name] = e
+	}
+	return e
+}
+
+// refresh 修改级别的频率很低，所以直接重新计算所有的 logger
+func (r *LevelRegistry) refresh() {
+	for name, e := range r.enablers {
+		e.level.Store(int32(r.effective(name)))
+	}
+}
+
+func (r *LevelRegistry) effective(name string) Level {
+	for name != "" {
+		if level, ok := r.overrides[name]; ok {
+			return level
+		}
+		idx := strings.LastIndexByte(name, '.')
+		if idx < 0 {
+			break
+		}
+		name = name[:idx]
+	}
+	return r.root
+}
+
+type levelEnabler struct {
+	level atomic.Int32
+}
+
+func (e *levelEnabler) Enabled(lvl Level) bool {
+	return lvl >= Level(e.level.Load())
+}
+
+type levelsResp struct {
+	Root Level `json:"root"`
+	// 单独设置过的
+	Overrides map[string]Level `json:"overrides"`
+	// 所有创建过的 logger 实际生效的级别
+	Loggers map[string]Level `json:"loggers"`
+}
+
+type setLevelReq struct {
+	Name string `json:"name"`
+	// 为空的时候恢复成使用父 logger 的级别
+	Level string `json:"level"`
+}
+
+// ServeHTTP 管理接口
+// GET 查询所有的级别
+// PUT 或者 POST 修改级别，例如 {"name": "saramax", "level": "debug"}，level 为空则取消单独设置
+func (r *LevelRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var sr setLevelReq
+		if err := json.NewDecoder(req.Body).Decode(&sr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if sr.Level == "" {
+			r.UnsetLevel(sr.Name)
+			break
+		}
+		level, err := ParseLevel(sr.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.SetLevel(sr.Name, level)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(r.snapshot())
+}
+
+func (r *LevelRegistry) snapshot() levelsResp {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	res := levelsResp{
+		Root:      r.root,
+		Overrides: make(map[string]Level, len(r.overrides)),
+		Loggers:   make(map[string]Level, len(r.enablers)),
+	}
+	for name, level := range r.overrides {
+		res.Overrides[name] = level
+	}
+	for name := range r.enablers {
+		if name != "" {
+			res.Loggers[name] = r.effective(name)
+		}
+	}
+	return res
+}
+
+// Names 返回所有创建过的 logger 的名字
+func (r *LevelRegistry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	res := make([]string, 0, len(r.enablers))
+	for name := range r.enablers {
+		if name != "" {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,155 @@
+package logger
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLevelRegistry(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	levels := NewLevelRegistry(InfoLevel)
+	root := NewZapLogger(zap.New(core)).Levels(levels)
+	sarama := root.Named("saramax")
+	retry := sarama.Named("retry").With(String("topic", "user"))
+	grpc := root.Named("grpc")
+
+	log := func() []string {
+		logs.TakeAll()
+		root.Debug("root")
+		sarama.Debug("saramax")
+		retry.Debug("retry")
+		grpc.Debug("grpc")
+		grpc.Info("grpc info")
+		var res []string
+		for _, e := range logs.TakeAll() {
+			res = append(res, e.Message)
+		}
+		return res
+	}
+	assert.Equal(t, []string{"grpc info"}, log())
+
+	// 子 logger 继承父 logger 的级别
+	levels.SetLevel("saramax", DebugLevel)
+	assert.Equal(t, []string{"saramax", "retry", "grpc info"}, log())
+
+	levels.SetLevel("saramax.retry", WarnLevel)
+	levels.SetLevel("grpc", ErrorLevel)
+	assert.Equal(t, []string{"saramax"}, log())
+
+	levels.UnsetLevel("saramax")
+	levels.UnsetLevel("grpc")
+	levels.SetLevel("", DebugLevel)
+	assert.Equal(t, []string{"root", "saramax", "grpc", "grpc info"}, log())
+	assert.Equal(t, WarnLevel, levels.Level("saramax.retry"))
+	assert.Equal(t, DebugLevel, levels.Level("saramax.other"))
+	assert.Equal(t, []string{"grpc", "saramax", "saramax.retry"}, levels.Names())
+}
+
+func TestLevelRegistry_ServeHTTP(t *testing.T) {
+	levels := NewLevelRegistry(InfoLevel)
+	NewZapLogger(zap.NewNop()).Levels(levels).Named("saramax").Named("retry")
+
+	testCases := []struct {
+		name     string
+		method   string
+		body     string
+		wantCode int
+		wantResp levelsResp
+	}{
+		{
+			name:     "查询",
+			method:   http.MethodGet,
+			wantCode: http.StatusOK,
+			wantResp: levelsResp{
+				Root:      InfoLevel,
+				Overrides: map[string]Level{},
+				Loggers:   map[string]Level{"saramax": InfoLevel, "saramax.retry": InfoLevel},
+			},
+		},
+		{
+			name:     "修改",
+			method:   http.MethodPut,
+			body:     `{"name": "saramax", "level": "DEBUG"}`,
+			wantCode: http.StatusOK,
+			wantResp: levelsResp{
+				Root:      InfoLevel,
+				Overrides: map[string]Level{"saramax": DebugLevel},
+				Loggers:   map[string]Level{"saramax": DebugLevel, "saramax.retry": DebugLevel},
+			},
+		},
+		{
+			name:     "修改 root",
+			method:   http.MethodPost,
+			body:     `{"level": "warn"}`,
+			wantCode: http.StatusOK,
+			wantResp: levelsResp{
+				Root:      WarnLevel,
+				Overrides: map[string]Level{"saramax": DebugLevel},
+				Loggers:   map[string]Level{"saramax": DebugLevel, "saramax.retry": DebugLevel},
+			},
+		},
+		{
+			name:     "取消",
+			method:   http.MethodPut,
+			body:     `{"name": "saramax"}`,
+			wantCode: http.StatusOK,
+			wantResp: levelsResp{
+				Root:      WarnLevel,
+				Overrides: map[string]Level{},
+				Loggers:   map[string]Level{"saramax": WarnLevel, "saramax.retry": WarnLevel},
+			},
+		},
+		{
+			name:     "非法级别",
+			method:   http.MethodPut,
+			body:     `{"name": "saramax", "level": "fatal"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "非法请求",
+			method:   http.MethodPut,
+			body:     `{`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "不支持的方法",
+			method:   http.MethodDelete,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/admin/log/level", strings.NewReader(tc.body))
+			recorder := httptest.NewRecorder()
+			levels.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+			var resp levelsResp
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+			assert.Equal(t, tc.wantResp, resp)
+		})
+	}
+}
+
+func TestSlogLogger_Levels(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	levels := NewLevelRegistry(WarnLevel)
+	l := NewSlogLogger(NewSlogHandler(NewZapLogger(zap.New(core)))).Levels(levels)
+	sarama := l.Named("saramax")
+	sarama.Info("ignored")
+	levels.SetLevel("saramax", DebugLevel)
+	sarama.Debug("debug")
+	l.Info("ignored")
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "debug", entries[0].Message)
+}
@@ -0,0 +1,249 @@
+package loggertest
+
+import (
+	"context"
+	"fmt"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Entry 一条日志
+type Entry struct {
+	Level   logger.Level
+	Name    string
+	Message string
+	// 包括 With 绑定的字段和 ctx 中提取出来的字段
+	Fields []logger.Field
+}
+
+// Value 返回 key 对应的值，有重复的 key 的时候以最后一个为准
+func (e Entry) Value(key string) (any, bool) {
+	for i := len(e.Fields) - 1; i >= 0; i-- {
+		if e.Fields[i].Key == key {
+			return e.Fields[i].Value(), true
+		}
+	}
+	return nil, false
+}
+
+// ContextMap 把字段转换成 map，Group 会转换成嵌套的 map
+func (e Entry) ContextMap() map[string]any {
+	return toMap(e.Fields)
+}
+
+// Has 是否包含了所有的 fields，比较的是 Field.Value
+func (e Entry) Has(fields ...logger.Field) bool {
+	for _, f := range fields {
+		val, ok := e.Value(f.Key)
+		if !ok || !reflect.DeepEqual(val, f.Value()) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("[%s] %s %s %v", e.Level, e.Name, e.Message, e.ContextMap())
+}
+
+// Logger 把日志记录在内存里面，用于测试
+// 通过 With、Named、WithLevel 创建的子 logger 和父 logger 记录在一起
+type Logger struct {
+	store  *store
+	name   string
+	fields []logger.Field
+	level  logger.Level
+}
+
+func NewLogger() *Logger {
+	return &Logger{store: &store{}, level: logger.DebugLevel}
+}
+
+func (l *Logger) Debug(msg string, args ...logger.Field) {
+	l.log(context.Background(), logger.DebugLevel, msg, args)
+}
+
+func (l *Logger) Info(msg string, args ...logger.Field) {
+	l.log(context.Background(), logger.InfoLevel, msg, args)
+}
+
+func (l *Logger) Warn(msg string, args ...logger.Field) {
+	l.log(context.Background(), logger.WarnLevel, msg, args)
+}
+
+func (l *Logger) Error(msg string, args ...logger.Field) {
+	l.log(context.Background(), logger.ErrorLevel, msg, args)
+}
+
+func (l *Logger) DebugCtx(ctx context.Context, msg string, args ...logger.Field) {
+	l.log(ctx, logger.DebugLevel, msg, args)
+}
+
+func (l *Logger) InfoCtx(ctx context.Context, msg string, args ...logger.Field) {
+	l.log(ctx, logger.InfoLevel, msg, args)
+}
+
+func (l *Logger) WarnCtx(ctx context.Context, msg string, args ...logger.Field) {
+	l.log(ctx, logger.WarnLevel, msg, args)
+}
+
+func (l *Logger) ErrorCtx(ctx context.Context, msg string, args ...logger.Field) {
+	l.log(ctx, logger.ErrorLevel, msg, args)
+}
+
+func (l *Logger) Enabled(lvl logger.Level) bool {
+	return l.level.Enabled(lvl)
+}
+
+func (l *Logger) With(args ...logger.Field) logger.Logger {
+	res := *l
+	res.fields = append(l.fields[:len(l.fields):len(l.fields)], args...)
+	return &res
+}
+
+func (l *Logger) Named(name string) logger.Logger {
+	res := *l
+	if l.name == "" {
+		res.name = name
+	} else {
+		res.name = l.name + "." + name
+	}
+	return &res
+}
+
+func (l *Logger) WithLevel(level logger.Level) logger.Logger {
+	res := *l
+	res.level = level
+	return &res
+}
+
+func (l *Logger) log(ctx context.Context, level logger.Level, msg string, args []logger.Field) {
+	if !l.level.Enabled(level) {
+		return
+	}
+	fields := make([]logger.Field, 0, len(l.fields)+len(args))
+	fields = append(fields, l.fields...)
+	fields = append(fields, logger.Extract(ctx)...)
+	fields = append(fields, args...)
+	l.store.add(Entry{Level: level, Name: l.name, Message: msg, Fields: fields})
+}
+
+// All 返回所有的日志
+func (l *Logger) All() []Entry {
+	return l.store.all()
+}
+
+// TakeAll 返回所有的日志并清空
+func (l *Logger) TakeAll() []Entry {
+	return l.store.takeAll()
+}
+
+func (l *Logger) Len() int {
+	return len(l.store.all())
+}
+
+func (l *Logger) Filter(fn func(e Entry) bool) []Entry {
+	var res []Entry
+	for _, e := range l.store.all() {
+		if fn(e) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func (l *Logger) FilterLevel(level logger.Level) []Entry {
+	return l.Filter(func(e Entry) bool {
+		return e.Level == level
+	})
+}
+
+func (l *Logger) FilterMessage(msg string) []Entry {
+	return l.Filter(func(e Entry) bool {
+		return e.Message == msg
+	})
+}
+
+// FilterField 包含 fields 的日志
+func (l *Logger) FilterField(fields ...logger.Field) []Entry {
+	return l.Filter(func(e Entry) bool {
+		return e.Has(fields...)
+	})
+}
+
+// AssertLogged 断言输出过级别为 level、消息为 msg，并且包含 fields 的日志
+func (l *Logger) AssertLogged(t testing.TB, level logger.Level, msg string, fields ...logger.Field) bool {
+	t.Helper()
+	res := l.Filter(func(e Entry) bool {
+		return e.Level == level && e.Message == msg && e.Has(fields...)
+	})
+	if len(res) > 0 {
+		return true
+	}
+	t.Errorf("没有找到日志 [%s] %s %v\n已有的日志:\n%s", level, msg, toMap(fields), l.dump())
+	return false
+}
+
+// AssertNotLogged 断言没有输出过级别为 level、消息为 msg 的日志
+func (l *Logger) AssertNotLogged(t testing.TB, level logger.Level, msg string) bool {
+	t.Helper()
+	res := l.Filter(func(e Entry) bool {
+		return e.Level == level && e.Message == msg
+	})
+	if len(res) == 0 {
+		return true
+	}
+	t.Errorf("不应该输出日志 [%s] %s\n已有的日志:\n%s", level, msg, l.dump())
+	return false
+}
+
+func (l *Logger) dump() string {
+	var sb strings.Builder
+	for _, e := range l.store.all() {
+		sb.WriteString(e.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+type store struct {
+	mutex   sync.RWMutex
+	entries []Entry
+}
+
+func (s *store) add(e Entry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = append(s.entries, e)
+}
+
+func (s *store) all() []Entry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	res := make([]Entry, len(s.entries))
+	copy(res, s.entries)
+	return res
+}
+
+func (s *store) takeAll() []Entry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	res := s.entries
+	s.entries = nil
+	return res
+}
+
+func toMap(fields []logger.Field) map[string]any {
+	res := make(map[string]any, len(fields))
+	for _, f := range fields {
+		if f.Type == logger.GroupType {
+			res[f.Key] = toMap(f.Val.([]logger.Field))
+			continue
+		}
+		res[f.Key] = f.Value()
+	}
+	return res
+}
@@ -1,5 +1,7 @@
 package logger
 
+import "context"
+
 type NoOpLogger struct {
 }
 
@@ -7,6 +9,14 @@ func (n *NoOpLogger) With(args ...Field) Logger {
 	return n
 }
 
+func (n *NoOpLogger) Named(name string) Logger {
+	return n
+}
+
+func (n *NoOpLogger) WithLevel(level Level) Logger {
+	return n
+}
+
 func NewNoOpLogger() Logger {
 	return &NoOpLogger{}
 }
@@ -21,3 +31,14 @@ func (n *NoOpLogger) Warn(msg string, args ...Field) {
 
 func (n *NoOpLogger) Error(msg string, args ...Field) {
 }
+
+func (n *NoOpLogger) DebugCtx(ctx context.Context, msg string, args ...Field) {}
+
+func (n *NoOpLogger) InfoCtx(ctx context.Context, msg string, args ...Field) {
+}
+
+func (n *NoOpLogger) WarnCtx(ctx context.Context, msg string, args ...Field) {
+}
+
+func (n *NoOpLogger) ErrorCtx(ctx context.Context, msg string, args ...Field) {
+}
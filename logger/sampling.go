@@ -0,0 +1,387 @@
+package logger
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SamplingLogger 按照日志级别 + 消息采样
+// 每个 interval 内，前 first 条都会输出，之后每 thereafter 条输出一条
+// 通过 With、Named、WithLevel 创建的子 logger 共用计数，Named 的名字也是 key 的一部分
+// 计数器的个数是固定的，不会随着消息的种类增长，hash 冲突的 key 共用一个计数
+type SamplingLogger struct {
+	l    Logger
+	name string
+	s    *sampler
+}
+
+// NewSamplingLogger thereafter 为 0 的时候，超过 first 条之后全部丢弃
+func NewSamplingLogger(l Logger, interval time.Duration, first, thereafter uint64) *SamplingLogger {
+	return &SamplingLogger{
+		l: l,
+		s: &sampler{
+			interval:   interval,
+			first:      first,
+			thereafter: thereafter,
+			now:        time.Now,
+		},
+	}
+}
+
+func (s *SamplingLogger) Debug(msg string, args ...Field) {
+	if s.check(DebugLevel, msg) {
+		s.l.Debug(msg, args...)
+	}
+}
+
+func (s *SamplingLogger) Info(msg string, args ...Field) {
+	if s.check(InfoLevel, msg) {
+		s.l.Info(msg, args...)
+	}
+}
+
+func (s *SamplingLogger) Warn(msg string, args ...Field) {
+	if s.check(WarnLevel, msg) {
+		s.l.Warn(msg, args...)
+	}
+}
+
+func (s *SamplingLogger) Error(msg string, args ...Field) {
+	if s.check(ErrorLevel, msg) {
+		s.l.Error(msg, args...)
+	}
+}
+
+func (s *SamplingLogger) DebugCtx(ctx context.Context, msg string, args ...Field) {
+	if s.check(DebugLevel, msg) {
+		s.l.DebugCtx(ctx, msg, args...)
+	}
+}
+
+func (s *SamplingLogger) InfoCtx(ctx context.Context, msg string, args ...Field) {
+	if s.check(InfoLevel, msg) {
+		s.l.InfoCtx(ctx, msg, args...)
+	}
+}
+
+func (s *SamplingLogger) WarnCtx(ctx context.Context, msg string, args ...Field) {
+	if s.check(WarnLevel, msg) {
+		s.l.WarnCtx(ctx, msg, args...)
+	}
+}
+
+func (s *SamplingLogger) ErrorCtx(ctx context.Context, msg string, args ...Field) {
+	if s.check(ErrorLevel, msg) {
+		s.l.ErrorCtx(ctx, msg, args...)
+	}
+}
+
+// Enabled 交给被装饰的 logger 判断
+func (s *SamplingLogger) Enabled(lvl Level) bool {
+	return enabled(s.l, lvl)
+}
+
+// check 先判断日志级别，不会输出的日志不占用采样的名额
+func (s *SamplingLogger) check(level Level, msg string) bool {
+	return enabled(s.l, level) && s.s.sample(s.name, level, msg)
+}
+
+func (s *SamplingLogger) With(args ...Field) Logger {
+	return &SamplingLogger{l: s.l.With(args...), name: s.name, s: s.s}
+}
+
+func (s *SamplingLogger) Named(name string) Logger {
+	return &SamplingLogger{l: s.l.Named(name), name: joinName(s.name, name), s: s.s}
+}
+
+func (s *SamplingLogger) WithLevel(level Level) Logger {
+	return &SamplingLogger{l: s.l.WithLevel(level), name: s.name, s: s.s}
+}
+
+// sampleTableSize 计数器的个数，key 按照 hash 分到固定的计数器上，
+// 所以内存不会随着消息的种类增长，代价是 hash 冲突的 key 共用一个计数
+const sampleTableSize = 4096
+
+type sampler struct {
+	interval   time.Duration
+	first      uint64
+	thereafter uint64
+	now        func() time.Time
+	counters   [sampleTableSize]sampleCounter
+}
+
+func (s *sampler) sample(name string, level Level, msg string) bool {
+	n := s.counters[sampleHash(name, level, msg)%sampleTableSize].incr(s.now(), s.interval)
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+// sampleHash FNV-1a，手写是为了不用分配内存
+func sampleHash(name string, level Level, msg string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	h := uint32(offset32)
+	for i := 0; i < len(name); i++ {
+		h = (h ^ uint32(name[i])) * prime32
+	}
+	// 分隔 name 和 msg，避免 "a" + "bc" 和 "ab" + "c" 一样
+	h = (h ^ uint32(uint8(level))) * prime32
+	for i := 0; i < len(msg); i++ {
+		h = (h ^ uint32(msg[i])) * prime32
+	}
+	return h
+}
+
+type sampleCounter struct {
+	resetAt atomic.Int64
+	cnt     atomic.Uint64
+}
+
+// incr 返回这个 interval 内是第几条，过了 interval 之后重新计数
+// 只有 CAS 成功的那个负责重置，其余的直接计数。和 zap 一样不保证精确：
+// 在 CAS 和 Store 之间别的 goroutine 加上的计数会被覆盖，结果是重置的时候可能多输出几条
+func (c *sampleCounter) incr(now time.Time, interval time.Duration) uint64 {
+	tn := now.UnixNano()
+	resetAt := c.resetAt.Load()
+	if resetAt > tn {
+		return c.cnt.Add(1)
+	}
+	if !c.resetAt.CompareAndSwap(resetAt, tn+int64(interval)) {
+		// 别人已经重置了
+		return c.cnt.Add(1)
+	}
+	c.cnt.Store(1)
+	return 1
+}
+
+// DedupeLogger 同一个 interval 内重复的日志只输出第一条
+// 日志级别、消息、名字和错误信息都相同的才算重复
+// 被丢弃的条数会在下一次输出的时候通过 suppressed 字段带上，
+// 之后没有再出现的，会在清理的时候带上最后一条被丢弃的日志的字段单独输出
+// 最多记录 dedupeMaxEntries 种日志，超过之后新的日志不去重，直接输出
+type DedupeLogger struct {
+	l    Logger
+	name string
+	d    *deduper
+}
+
+func NewDedupeLogger(l Logger, interval time.Duration) *DedupeLogger {
+	return &DedupeLogger{
+		l: l,
+		d: &deduper{
+			interval:   interval,
+			now:        time.Now,
+			entries:    make(map[dedupeKey]*dedupeEntry),
+			maxEntries: dedupeMaxEntries,
+		},
+	}
+}
+
+func (d *DedupeLogger) Debug(msg string, args ...Field) {
+	if ok, args := d.check(DebugLevel, msg, args); ok {
+		d.l.Debug(msg, args...)
+	}
+}
+
+func (d *DedupeLogger) Info(msg string, args ...Field) {
+	if ok, args := d.check(InfoLevel, msg, args); ok {
+		d.l.Info(msg, args...)
+	}
+}
+
+func (d *DedupeLogger) Warn(msg string, args ...Field) {
+	if ok, args := d.check(WarnLevel, msg, args); ok {
+		d.l.Warn(msg, args...)
+	}
+}
+
+func (d *DedupeLogger) Error(msg string, args ...Field) {
+	if ok, args := d.check(ErrorLevel, msg, args); ok {
+		d.l.Error(msg, args...)
+	}
+}
+
+func (d *DedupeLogger) DebugCtx(ctx context.Context, msg string, args ...Field) {
+	if ok, args := d.check(DebugLevel, msg, args); ok {
+		d.l.DebugCtx(ctx, msg, args...)
+	}
+}
+
+func (d *DedupeLogger) InfoCtx(ctx context.Context, msg string, args ...Field) {
+	if ok, args := d.check(InfoLevel, msg, args); ok {
+		d.l.InfoCtx(ctx, msg, args...)
+	}
+}
+
+func (d *DedupeLogger) WarnCtx(ctx context.Context, msg string, args ...Field) {
+	if ok, args := d.check(WarnLevel, msg, args); ok {
+		d.l.WarnCtx(ctx, msg, args...)
+	}
+}
+
+func (d *DedupeLogger) ErrorCtx(ctx context.Context, msg string, args ...Field) {
+	if ok, args := d.check(ErrorLevel, msg, args); ok {
+		d.l.ErrorCtx(ctx, msg, args...)
+	}
+}
+
+// Enabled 交给被装饰的 logger 判断
+func (d *DedupeLogger) Enabled(lvl Level) bool {
+	return enabled(d.l, lvl)
+}
+
+func (d *DedupeLogger) check(level Level, msg string, args []Field) (bool, []Field) {
+	if !enabled(d.l, level) {
+		return false, args
+	}
+	return d.d.check(d.l, d.name, level, msg, args)
+}
+
+func (d *DedupeLogger) With(args ...Field) Logger {
+	return &DedupeLogger{l: d.l.With(args...), name: d.name, d: d.d}
+}
+
+func (d *DedupeLogger) Named(name string) Logger {
+	return &DedupeLogger{l: d.l.Named(name), name: joinName(d.name, name), d: d.d}
+}
+
+func (d *DedupeLogger) WithLevel(level Level) Logger {
+	return &DedupeLogger{l: d.l.WithLevel(level), name: d.name, d: d.d}
+}
+
+type dedupeKey struct {
+	name  string
+	level Level
+	msg   string
+	err   string
+}
+
+// dedupeMaxEntries 错误信息也是 key 的一部分，要限制 entry 的个数
+const dedupeMaxEntries = 4096
+
+type dedupeEntry struct {
+	// 在这之前重复的都丢弃
+	until      time.Time
+	suppressed int64
+	// 最后一条被丢弃的日志，清理的时候用来输出 suppressed
+	l    Logger
+	args []Field
+}
+
+type deduper struct {
+	interval   time.Duration
+	now        func() time.Time
+	mutex      sync.Mutex
+	entries    map[dedupeKey]*dedupeEntry
+	maxEntries int
+	// 上一次清理过期 entry 的时间
+	lastSweep time.Time
+}
+
+func (d *deduper) check(l Logger, name string, level Level, msg string, args []Field) (bool, []Field) {
+	key := dedupeKey{name: name, level: level, msg: msg, err: errorText(args)}
+	now := d.now()
+	d.mutex.Lock()
+	expired := d.sweep(now, key)
+	ok, args := d.checkLocked(l, key, now, args)
+	d.mutex.Unlock()
+	// 不在锁里面输出
+	for key, entry := range expired {
+		logAt(entry.l, key.level, key.msg,
+			append(entry.args[:len(entry.args):len(entry.args)], Int64("suppressed", entry.suppressed)))
+	}
+	return ok, args
+}
+
+func (d *deduper) checkLocked(l Logger, key dedupeKey, now time.Time, args []Field) (bool, []Field) {
+	entry, ok := d.entries[key]
+	if !ok {
+		if len(d.entries) < d.maxEntries {
+			d.entries[key] = &dedupeEntry{until: now.Add(d.interval)}
+		}
+		return true, args
+	}
+	if now.Before(entry.until) {
+		entry.suppressed++
+		entry.l, entry.args = l, args
+		return false, args
+	}
+	suppressed := entry.suppressed
+	entry.until = now.Add(d.interval)
+	entry.suppressed = 0
+	entry.l, entry.args = nil, nil
+	if suppressed > 0 {
+		args = append(args[:len(args):len(args)], Int64("suppressed", suppressed))
+	}
+	return true, args
+}
+
+// sweep 删除所有过期的 entry，避免 map 无限增长
+// 返回丢弃过日志的 entry，由调用方输出 suppressed，不然这些条数就丢了
+// current 是正在输出的这一条，它的 suppressed 会跟着它一起输出，所以跳过
+func (d *deduper) sweep(now time.Time, current dedupeKey) map[dedupeKey]*dedupeEntry {
+	if now.Sub(d.lastSweep) < d.interval {
+		return nil
+	}
+	d.lastSweep = now
+	var expired map[dedupeKey]*dedupeEntry
+	for key, entry := range d.entries {
+		if key == current || now.Before(entry.until) {
+			continue
+		}
+		delete(d.entries, key)
+		if entry.suppressed > 0 {
+			if expired == nil {
+				expired = make(map[dedupeKey]*dedupeEntry)
+			}
+			expired[key] = entry
+		}
+	}
+	return expired
+}
+
+func errorText(args []Field) string {
+	for _, arg := range args {
+		if arg.Type != ErrorType {
+			continue
+		}
+		if err, ok := arg.Val.(error); ok && err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+func logAt(l Logger, level Level, msg string, args []Field) {
+	switch level {
+	case DebugLevel:
+		l.Debug(msg, args...)
+	case InfoLevel:
+		l.Info(msg, args...)
+	case WarnLevel:
+		l.Warn(msg, args...)
+	default:
+		l.Error(msg, args...)
+	}
+}
+
+// enabled l 没有实现 LevelEnabler 的时候，认为所有级别都会输出
+func enabled(l Logger, lvl Level) bool {
+	if le, ok := l.(LevelEnabler); ok {
+		return le.Enabled(lvl)
+	}
+	return true
+}
+
+func joinName(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+	"time"
+)
+
+func TestSamplingLogger(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	l := NewSamplingLogger(NewZapLogger(zap.New(core)), time.Second, 2, 3)
+	now := time.UnixMilli(1700000000000)
+	l.s.now = func() time.Time {
+		return now
+	}
+
+	for i := 1; i <= 10; i++ {
+		l.Info("rpc", Int("i", i))
+	}
+	// 不同的消息、级别、名字分开计数
+	l.Warn("rpc", Int("i", 1))
+	l.Info("other", Int("i", 1))
+	named := l.Named("grpc").With(String("k", "v"))
+	named.Info("rpc", Int("i", 1))
+	named.Info("rpc", Int("i", 2))
+	named.Info("rpc", Int("i", 3))
+	// 下一个 interval 重新计数
+	now = now.Add(time.Second)
+	l.Info("rpc", Int("i", 11))
+
+	var res []string
+	for _, e := range logs.AllUntimed() {
+		res = append(res, fmt.Sprintf("%s|%s|%s|%d", e.LoggerName, e.Message, e.Level, e.ContextMap()["i"]))
+	}
+	assert.Equal(t, []string{
+		"|rpc|info|1",
+		"|rpc|info|2",
+		"|rpc|info|5",
+		"|rpc|info|8",
+		"|rpc|warn|1",
+		"|other|info|1",
+		"grpc|rpc|info|1",
+		"grpc|rpc|info|2",
+		"|rpc|info|11",
+	}, res)
+}
+
+func TestSamplingLogger_Level(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	l := NewSamplingLogger(NewZapLogger(zap.New(core)), time.Minute, 1, 0)
+	// 没有开启的级别不占用采样的名额
+	for i := 0; i < 3; i++ {
+		l.Debug("rpc")
+	}
+	assert.False(t, l.Enabled(DebugLevel))
+	debug := l.WithLevel(DebugLevel)
+	debug.Debug("rpc")
+	debug.Debug("rpc")
+	assert.Equal(t, 1, logs.FilterMessage("rpc").Len())
+}
+
+func TestSampler(t *testing.T) {
+	s := &sampler{interval: time.Second, first: 1, now: time.Now}
+	msgs := make([]string, 10000)
+	for i := range msgs {
+		msgs[i] = fmt.Sprintf("msg-%d", i)
+	}
+	// 计数器是固定大小的，消息的种类再多也不会分配内存
+	i := 0
+	allocs := testing.AllocsPerRun(len(msgs), func() {
+		s.sample("", InfoLevel, msgs[i%len(msgs)])
+		i++
+	})
+	assert.Equal(t, float64(0), allocs)
+
+	var c sampleCounter
+	now := time.UnixMilli(1700000000000)
+	assert.Equal(t, uint64(1), c.incr(now, time.Second))
+	assert.Equal(t, uint64(2), c.incr(now, time.Second))
+	assert.Equal(t, uint64(3), c.incr(now.Add(time.Second-1), time.Second))
+	assert.Equal(t, uint64(1), c.incr(now.Add(time.Second), time.Second))
+	assert.Equal(t, uint64(2), c.incr(now.Add(time.Second), time.Second))
+}
+
+func TestDedupeLogger(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	l := NewDedupeLogger(NewZapLogger(zap.New(core)), time.Minute)
+	now := time.UnixMilli(1700000000000)
+	l.d.now = func() time.Time {
+		return now
+	}
+	decodeErr := errors.New("invalid character")
+	for i := 0; i < 5; i++ {
+		l.Error("反序列消息体失败", Int("offset", i), Error(decodeErr))
+	}
+	// 错误不同的不算重复
+	l.Error("反序列消息体失败", Int("offset", 5), Error(errors.New("unexpected EOF")))
+	now = now.Add(time.Minute)
+	l.Error("反序列消息体失败", Int("offset", 6), Error(decodeErr))
+	l.Error("反序列消息体失败", Int("offset", 7), Error(decodeErr))
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 3)
+	assert.Equal(t, int64(0), entries[0].ContextMap()["offset"])
+	assert.Equal(t, int64(5), entries[1].ContextMap()["offset"])
+	assert.Equal(t, int64(6), entries[2].ContextMap()["offset"])
+	assert.Equal(t, int64(4), entries[2].ContextMap()["suppressed"])
+}
+
+func TestDedupeLogger_Sweep(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	l := NewDedupeLogger(NewZapLogger(zap.New(core)), time.Minute)
+	l.d.maxEntries = 2
+	now := time.UnixMilli(1700000000000)
+	l.d.now = func() time.Time {
+		return now
+	}
+	for i := 0; i < 3; i++ {
+		l.Warn("连接失败", Int("i", i), Error(errors.New("timeout")))
+	}
+	l.Warn("连接失败", Error(errors.New("refused")))
+	// 超过上限之后不去重
+	l.Warn("连接失败", Int("i", 0), Error(errors.New("reset")))
+	l.Warn("连接失败", Int("i", 1), Error(errors.New("reset")))
+	assert.Equal(t, 4, logs.Len())
+	assert.Len(t, l.d.entries, 2)
+
+	// timeout 之后没有再出现，清理的时候单独输出被丢弃的条数
+	now = now.Add(time.Minute)
+	l.Info("other")
+	entries := logs.TakeAll()
+	assert.Len(t, entries, 6)
+	summary := entries[4]
+	assert.Equal(t, "连接失败", summary.Message)
+	assert.Equal(t, zap.WarnLevel, summary.Level)
+	assert.Equal(t, map[string]any{
+		"i":          int64(2),
+		"error":      "timeout",
+		"suppressed": int64(2),
+	}, summary.ContextMap())
+	// 过期的都删掉了，只剩下 other
+	assert.Len(t, l.d.entries, 1)
+}
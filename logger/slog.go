@@ -0,0 +1,299 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"runtime"
+	"time"
+)
+
+// SlogLogger 基于 slog.Handler 实现的 Logger
+type SlogLogger struct {
+	h    slog.Handler
+	name string
+	// 不为 nil 的时候，覆盖 h 的日志级别
+	level LevelEnabler
+	// 不为 nil 的时候，Named 创建的子 logger 的级别由 levels 控制
+	levels *LevelRegistry
+}
+
+func NewSlogLogger(h slog.Handler) *SlogLogger {
+	return &SlogLogger{h: h}
+}
+
+// Levels 使用 levels 在运行时控制日志级别，每个 Named 的子 logger 都可以单独设置
+func (s *SlogLogger) Levels(levels *LevelRegistry) *SlogLogger {
+	s.levels = levels
+	s.level = levels.enabler(s.name)
+	return s
+}
+
+func (s *SlogLogger) Debug(msg string, args ...Field) {
+	s.log(context.Background(), DebugLevel, msg, args)
+}
+
+func (s *SlogLogger) Info(msg string, args ...Field) {
+	s.log(context.Background(), InfoLevel, msg, args)
+}
+
+func (s *SlogLogger) Warn(msg string, args ...Field) {
+	s.log(context.Background(), WarnLevel, msg, args)
+}
+
+func (s *SlogLogger) Error(msg string, args ...Field) {
+	s.log(context.Background(), ErrorLevel, msg, args)
+}
+
+func (s *SlogLogger) DebugCtx(ctx context.Context, msg string, args ...Field) {
+	s.log(ctx, DebugLevel, msg, withContext(ctx, args))
+}
+
+func (s *SlogLogger) InfoCtx(ctx context.Context, msg string, args ...Field) {
+	s.log(ctx, InfoLevel, msg, withContext(ctx, args))
+}
+
+func (s *SlogLogger) WarnCtx(ctx context.Context, msg string, args ...Field) {
+	s.log(ctx, WarnLevel, msg, withContext(ctx, args))
+}
+
+func (s *SlogLogger) ErrorCtx(ctx context.Context, msg string, args ...Field) {
+	s.log(ctx, ErrorLevel, msg, withContext(ctx, args))
+}
+
+// Enabled 级别为 lvl 的日志会不会输出
+func (s *SlogLogger) Enabled(lvl Level) bool {
+	return s.enabled(context.Background(), lvl)
+}
+
+func (s *SlogLogger) With(args ...Field) Logger {
+	if len(args) == 0 {
+		return s
+	}
+	res := *s
+	res.h = s.h.WithAttrs(toAttrs(args))
+	return &res
+}
+
+// Named slog 没有名字的概念，所以名字会作为 logger 字段输出
+func (s *SlogLogger) Named(name string) Logger {
+	res := *s
+	res.name = joinName(s.name, name)
+	if res.levels != nil {
+		res.level = res.levels.enabler(res.name)
+	}
+	return &res
+}
+
+func (s *SlogLogger) WithLevel(level Level) Logger {
+	res := *s
+	res.level = level
+	return &res
+}
+
+func (s *SlogLogger) log(ctx context.Context, level Level, msg string, args []Field) {
+	if !s.enabled(ctx, level) {
+		return
+	}
+	// 跳过 runtime.Callers、log 和 Info 之类的方法
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), level.slogLevel(), msg, pcs[0])
+	if s.name != "" {
+		r.AddAttrs(slog.String("logger", s.name))
+	}
+	r.AddAttrs(toAttrs(args)...)
+	_ = s.h.Handle(ctx, r)
+}
+
+func (s *SlogLogger) enabled(ctx context.Context, level Level) bool {
+	if s.level != nil {
+		return s.level.Enabled(level)
+	}
+	return s.h.Enabled(ctx, level.slogLevel())
+}
+
+func toAttrs(args []Field) []slog.Attr {
+	res := make([]slog.Attr, 0, len(args))
+	for _, arg := range args {
+		res = append(res, toAttr(arg))
+	}
+	return res
+}
+
+func toAttr(arg Field) slog.Attr {
+	switch arg.Type {
+	case StringType:
+		return slog.String(arg.Key, arg.Str)
+	case Int64Type:
+		return slog.Int64(arg.Key, arg.Integer)
+	case Uint64Type:
+		return slog.Uint64(arg.Key, uint64(arg.Integer))
+	case BoolType:
+		return slog.Bool(arg.Key, arg.Integer == 1)
+	case Float64Type:
+		return slog.Float64(arg.Key, math.Float64frombits(uint64(arg.Integer)))
+	case DurationType:
+		return slog.Duration(arg.Key, time.Duration(arg.Integer))
+	case GroupType:
+		return slog.Attr{Key: arg.Key, Value: slog.GroupValue(toAttrs(arg.Val.([]Field))...)}
+	default:
+		return slog.Any(arg.Key, arg.Value())
+	}
+}
+
+// SlogHandler 基于 Logger 实现的 slog.Handler
+// 用 slog 的第三方库可以和业务代码输出到同一个地方
+type SlogHandler struct {
+	l     Logger
+	level slog.Leveler
+	// WithGroup 和 WithAttrs 的调用记录
+	// 没有 group 的时候 WithAttrs 会直接调用 Logger.With
+	groups []groupOrAttrs
+}
+
+type groupOrAttrs struct {
+	group string
+	attrs []Field
+}
+
+func NewSlogHandler(l Logger) *SlogHandler {
+	return &SlogHandler{l: l}
+}
+
+// Level 设置 Enabled 使用的级别，默认全部交给 Logger 判断
+func (h *SlogHandler) Level(level slog.Leveler) *SlogHandler {
+	h.level = level
+	return h
+}
+
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.level == nil {
+		return true
+	}
+	return level >= h.level.Level()
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := make([]Field, 0, r.NumAttrs())
+	r.Attrs(func(attr slog.Attr) bool {
+		fields = appendAttr(fields, attr)
+		return true
+	})
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		g := h.groups[i]
+		if g.group == "" {
+			fields = append(g.attrs[:len(g.attrs):len(g.attrs)], fields...)
+			continue
+		}
+		// 空的 group 会被忽略
+		if len(fields) > 0 {
+			fields = []Field{Group(g.group, fields...)}
+		}
+	}
+	switch levelFromSlog(r.Level) {
+	case DebugLevel:
+		h.l.DebugCtx(ctx, r.Message, fields...)
+	case InfoLevel:
+		h.l.InfoCtx(ctx, r.Message, fields...)
+	case WarnLevel:
+		h.l.WarnCtx(ctx, r.Message, fields...)
+	default:
+		h.l.ErrorCtx(ctx, r.Message, fields...)
+	}
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make([]Field, 0, len(attrs))
+	for _, attr := range attrs {
+		fields = appendAttr(fields, attr)
+	}
+	if len(fields) == 0 {
+		return h
+	}
+	res := *h
+	if len(h.groups) == 0 {
+		res.l = h.l.With(fields...)
+		return &res
+	}
+	res.groups = append(h.groups[:len(h.groups):len(h.groups)], groupOrAttrs{attrs: fields})
+	return &res
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	res := *h
+	res.groups = append(h.groups[:len(h.groups):len(h.groups)], groupOrAttrs{group: name})
+	return &res
+}
+
+// appendAttr 按照 slog.Handler 的约定转换：忽略空的 attr，key 为空的 group 直接展开
+func appendAttr(fields []Field, attr slog.Attr) []Field {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+	switch attr.Value.Kind() {
+	case slog.KindGroup:
+		return appendGroup(fields, attr)
+	case slog.KindString:
+		return append(fields, String(attr.Key, attr.Value.String()))
+	case slog.KindInt64:
+		return append(fields, Int64(attr.Key, attr.Value.Int64()))
+	case slog.KindUint64:
+		return append(fields, Uint64(attr.Key, attr.Value.Uint64()))
+	case slog.KindBool:
+		return append(fields, Bool(attr.Key, attr.Value.Bool()))
+	case slog.KindFloat64:
+		return append(fields, Float64(attr.Key, attr.Value.Float64()))
+	case slog.KindDuration:
+		return append(fields, Duration(attr.Key, attr.Value.Duration()))
+	case slog.KindTime:
+		return append(fields, Time(attr.Key, attr.Value.Time()))
+	default:
+		return append(fields, Any(attr.Key, attr.Value.Any()))
+	}
+}
+
+func appendGroup(fields []Field, attr slog.Attr) []Field {
+	var group []Field
+	for _, a := range attr.Value.Group() {
+		group = appendAttr(group, a)
+	}
+	if len(group) == 0 {
+		return fields
+	}
+	if attr.Key == "" {
+		return append(fields, group...)
+	}
+	return append(fields, Group(attr.Key, group...))
+}
+
+func (l Level) slogLevel() slog.Level {
+	switch l {
+	case DebugLevel:
+		return slog.LevelDebug
+	case InfoLevel:
+		return slog.LevelInfo
+	case WarnLevel:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+func levelFromSlog(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return DebugLevel
+	case level < slog.LevelWarn:
+		return InfoLevel
+	case level < slog.LevelError:
+		return WarnLevel
+	default:
+		return ErrorLevel
+	}
+}
@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/slogtest"
+)
+
+func TestSlogLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var l Logger = NewSlogLogger(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	l = l.Named("saramax").With(String("topic", "user"))
+	l.Debug("ignored")
+	l.WithLevel(DebugLevel).Debug("debug",
+		Int64("offset", 12),
+		Group("req", String("id", "r1"), Int32("retry", 2)))
+	l.Error("failed", Error(errors.New("mock error")))
+
+	var entries []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var entry map[string]any
+		require.NoError(t, dec.Decode(&entry))
+		delete(entry, "time")
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, []map[string]any{
+		{
+			"level":  "DEBUG",
+			"msg":    "debug",
+			"logger": "saramax",
+			"topic":  "user",
+			"offset": float64(12),
+			"req":    map[string]any{"id": "r1", "retry": float64(2)},
+		},
+		{
+			"level":  "ERROR",
+			"msg":    "failed",
+			"logger": "saramax",
+			"topic":  "user",
+			"error":  "mock error",
+		},
+	}, entries)
+}
+
+func TestSlogHandler(t *testing.T) {
+	var logs *observer.ObservedLogs
+	newHandler := func(t *testing.T) slog.Handler {
+		if strings.HasSuffix(t.Name(), "/zero-time") {
+			t.Skip("时间由 Logger 决定，无法忽略 Record.Time")
+		}
+		var core zapcore.Core
+		core, logs = observer.New(zap.DebugLevel)
+		return NewSlogHandler(NewZapLogger(zap.New(core)))
+	}
+	result := func(t *testing.T) map[string]any {
+		entries := logs.AllUntimed()
+		require.Len(t, entries, 1)
+		res := entries[0].ContextMap()
+		res[slog.MessageKey] = entries[0].Message
+		res[slog.LevelKey] = entries[0].Level
+		res[slog.TimeKey] = entries[0].Time
+		return res
+	}
+	slogtest.Run(t, newHandler, result)
+}
+
+func TestSlogHandler_Level(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	l := slog.New(NewSlogHandler(NewZapLogger(zap.New(core))).Level(slog.LevelWarn))
+	ctx := WithContext(context.Background(), String("request_id", "r1"))
+	l.InfoContext(ctx, "ignored")
+	l.WarnContext(ctx, "warn", "a", 1)
+	l.Log(ctx, slog.LevelError+4, "error")
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "warn", entries[0].Message)
+	assert.Equal(t, map[string]any{"request_id": "r1", "a": int64(1)}, entries[0].ContextMap())
+	assert.Equal(t, zap.ErrorLevel, entries[1].Level)
+}
@@ -1,28 +1,23 @@
 package logger
 
+import "context"
+
 type Logger interface {
 	Debug(msg string, args ...Field)
 	Info(msg string, args ...Field)
 	Warn(msg string, args ...Field)
 	Error(msg string, args ...Field)
-}
-
-type Field struct {
-	Key string
-	Val any
-}
 
-func String(key string, val string) Field {
-	return Field{Key: key, Val: val}
-}
-
-func Int32(key string, val int32) Field {
-	return Field{Key: key, Val: val}
-}
-func Int64(key string, val int64) Field {
-	return Field{Key: key, Val: val}
-}
+	// DebugCtx 等方法会通过已注册的 Extractor 从 ctx 里面提取字段
+	DebugCtx(ctx context.Context, msg string, args ...Field)
+	InfoCtx(ctx context.Context, msg string, args ...Field)
+	WarnCtx(ctx context.Context, msg string, args ...Field)
+	ErrorCtx(ctx context.Context, msg string, args ...Field)
 
-func Error(err error) Field {
-	return Field{Key: "error", Val: err}
+	// With 返回一个子 logger，打印日志的时候都会带上 args
+	With(args ...Field) Logger
+	// Named 返回一个命名的子 logger，多次调用的时候名字用 . 连接
+	Named(name string) Logger
+	// WithLevel 返回一个子 logger，使用 level 作为日志级别，不影响父 logger
+	WithLevel(level Level) Logger
 }
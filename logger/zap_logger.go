@@ -1,9 +1,16 @@
 package logger
 
-import "go.uber.org/zap"
+import (
+	"context"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"time"
+)
 
 type ZapLogger struct {
 	l *zap.Logger
+	// 不为 nil 的时候，Named 创建的子 logger 的级别由 levels 控制
+	levels *LevelRegistry
 }
 
 func NewZapLogger(l *zap.Logger) *ZapLogger {
@@ -12,26 +19,157 @@ func NewZapLogger(l *zap.Logger) *ZapLogger {
 	}
 }
 
+// Levels 使用 levels 在运行时控制日志级别，每个 Named 的子 logger 都可以单独设置
+// 注意 zap.Logger 原本的级别会被忽略
+func (z *ZapLogger) Levels(levels *LevelRegistry) *ZapLogger {
+	z.levels = levels
+	z.l = z.withLevel(levels.enabler(z.l.Name()))
+	return z
+}
+
+// Debug 先判断日志级别，没有开启的时候不需要转换字段，Lazy 字段也不会被计算
+// 所有的方法都直接调用 z.l.Check，不能再套一层，否则 zap.AddCallerSkip(1) 拿到的调用方就不对了
 func (z *ZapLogger) Debug(msg string, args ...Field) {
-	z.l.Debug(msg, z.toArgs(args)...)
+	if ce := z.l.Check(zapcore.DebugLevel, msg); ce != nil {
+		ce.Write(z.toArgs(args)...)
+	}
 }
 
 func (z *ZapLogger) Info(msg string, args ...Field) {
-	z.l.Info(msg, z.toArgs(args)...)
+	if ce := z.l.Check(zapcore.InfoLevel, msg); ce != nil {
+		ce.Write(z.toArgs(args)...)
+	}
 }
 
 func (z *ZapLogger) Warn(msg string, args ...Field) {
-	z.l.Warn(msg, z.toArgs(args)...)
+	if ce := z.l.Check(zapcore.WarnLevel, msg); ce != nil {
+		ce.Write(z.toArgs(args)...)
+	}
 }
 
 func (z *ZapLogger) Error(msg string, args ...Field) {
-	z.l.Error(msg, z.toArgs(args)...)
+	if ce := z.l.Check(zapcore.ErrorLevel, msg); ce != nil {
+		ce.Write(z.toArgs(args)...)
+	}
+}
+
+func (z *ZapLogger) DebugCtx(ctx context.Context, msg string, args ...Field) {
+	if ce := z.l.Check(zapcore.DebugLevel, msg); ce != nil {
+		ce.Write(z.toArgs(withContext(ctx, args))...)
+	}
+}
+
+func (z *ZapLogger) InfoCtx(ctx context.Context, msg string, args ...Field) {
+	if ce := z.l.Check(zapcore.InfoLevel, msg); ce != nil {
+		ce.Write(z.toArgs(withContext(ctx, args))...)
+	}
+}
+
+func (z *ZapLogger) WarnCtx(ctx context.Context, msg string, args ...Field) {
+	if ce := z.l.Check(zapcore.WarnLevel, msg); ce != nil {
+		ce.Write(z.toArgs(withContext(ctx, args))...)
+	}
+}
+
+func (z *ZapLogger) ErrorCtx(ctx context.Context, msg string, args ...Field) {
+	if ce := z.l.Check(zapcore.ErrorLevel, msg); ce != nil {
+		ce.Write(z.toArgs(withContext(ctx, args))...)
+	}
+}
+
+// Enabled 级别为 lvl 的日志会不会输出
+func (z *ZapLogger) Enabled(lvl Level) bool {
+	return z.l.Core().Enabled(zapcore.Level(lvl))
+}
+
+func (z *ZapLogger) With(args ...Field) Logger {
+	return &ZapLogger{l: z.l.With(z.toArgs(args)...), levels: z.levels}
+}
+
+func (z *ZapLogger) Named(name string) Logger {
+	res := &ZapLogger{l: z.l.Named(name), levels: z.levels}
+	if res.levels != nil {
+		res.l = res.withLevel(res.levels.enabler(res.l.Name()))
+	}
+	return res
+}
+
+func (z *ZapLogger) WithLevel(level Level) Logger {
+	return &ZapLogger{l: z.withLevel(level), levels: z.levels}
+}
+
+func (z *ZapLogger) withLevel(level LevelEnabler) *zap.Logger {
+	return z.l.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return newLevelCore(core, level)
+	}))
 }
 
 func (z *ZapLogger) toArgs(args []Field) []zap.Field {
 	res := make([]zap.Field, 0, len(args))
 	for _, arg := range args {
-		res = append(res, zap.Any(arg.Key, arg.Val))
+		res = append(res, toZapField(arg))
 	}
 	return res
 }
+
+// toZapField 按照 FieldType 转换成 zap 对应类型的字段，只有 AnyType 需要 zap.Any 反射
+func toZapField(arg Field) zap.Field {
+	switch arg.Type {
+	case StringType:
+		return zap.String(arg.Key, arg.Str)
+	case Int64Type:
+		return zap.Int64(arg.Key, arg.Integer)
+	case Uint64Type:
+		return zap.Uint64(arg.Key, uint64(arg.Integer))
+	case BoolType:
+		return zap.Bool(arg.Key, arg.Integer == 1)
+	case Float64Type:
+		return zapcore.Field{Key: arg.Key, Type: zapcore.Float64Type, Integer: arg.Integer}
+	case DurationType:
+		return zap.Duration(arg.Key, time.Duration(arg.Integer))
+	case TimeType:
+		return zapcore.Field{Key: arg.Key, Type: zapcore.TimeType, Integer: arg.Integer, Interface: arg.Val}
+	case TimeFullType:
+		return zapcore.Field{Key: arg.Key, Type: zapcore.TimeFullType, Interface: arg.Val}
+	case BytesType:
+		return zap.Binary(arg.Key, arg.Val.([]byte))
+	case StringerType:
+		if arg.Val == nil {
+			return zap.String(arg.Key, "<nil>")
+		}
+		return zapcore.Field{Key: arg.Key, Type: zapcore.StringerType, Interface: arg.Val}
+	case ErrorType:
+		if arg.Val == nil {
+			return zap.Skip()
+		}
+		return zap.NamedError(arg.Key, arg.Val.(error))
+	case ObjectType:
+		obj, ok := arg.Val.(zapcore.ObjectMarshaler)
+		if !ok {
+			return zap.Reflect(arg.Key, nil)
+		}
+		return zap.Object(arg.Key, obj)
+	case ArrayType:
+		arr, ok := arg.Val.(zapcore.ArrayMarshaler)
+		if !ok {
+			return zap.Reflect(arg.Key, nil)
+		}
+		return zap.Array(arg.Key, arr)
+	case GroupType:
+		return zap.Object(arg.Key, zapFields(arg.Val.([]Field)))
+	case LazyType:
+		return zap.Any(arg.Key, arg.Value())
+	default:
+		return zap.Any(arg.Key, arg.Val)
+	}
+}
+
+// zapFields 用来输出 Group
+type zapFields []Field
+
+func (fs zapFields) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, f := range fs {
+		toZapField(f).AddTo(enc)
+	}
+	return nil
+}
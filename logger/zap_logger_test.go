@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"path/filepath"
+	"testing"
+)
+
+func TestZapLogger_With(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	l := NewZapLogger(zap.New(core))
+
+	child := l.Named("saramax").With(String("topic", "user"))
+	child.Named("retry").Info("hello", Int64("offset", 12))
+	l.Info("parent")
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "saramax.retry", entries[0].LoggerName)
+	assert.Equal(t, map[string]any{
+		"topic":  "user",
+		"offset": int64(12),
+	}, entries[0].ContextMap())
+	// 父 logger 不受影响
+	assert.Equal(t, "", entries[1].LoggerName)
+	assert.Empty(t, entries[1].ContextMap())
+}
+
+func TestZapLogger_WithLevel(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	l := NewZapLogger(zap.New(core))
+
+	debug := l.Named("debug").WithLevel(DebugLevel)
+	errLogger := l.Named("error").WithLevel(ErrorLevel)
+	// 多次覆盖以最后一次为准
+	warn := debug.WithLevel(WarnLevel).With(String("k", "v"))
+
+	l.Debug("parent debug")
+	l.Info("parent info")
+	debug.Debug("child debug")
+	errLogger.Warn("child warn")
+	errLogger.Error("child error")
+	warn.Info("warn info")
+	warn.Warn("warn warn")
+
+	var msgs []string
+	for _, e := range logs.AllUntimed() {
+		msgs = append(msgs, e.Message)
+	}
+	assert.Equal(t, []string{"parent info", "child debug", "child error", "warn warn"}, msgs)
+	assert.Equal(t, zapcore.DebugLevel, logs.AllUntimed()[1].Level)
+}
+
+func TestZapLogger_Caller(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	// 和业务里面一样跳过 ZapLogger 这一层
+	var l Logger = NewZapLogger(zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1)))
+	ctx := context.Background()
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+	l.DebugCtx(ctx, "debug")
+	l.InfoCtx(ctx, "info")
+	l.WarnCtx(ctx, "warn")
+	l.ErrorCtx(ctx, "error")
+	l.Named("child").With(String("k", "v")).Info("child")
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 9)
+	for _, e := range entries {
+		assert.Equal(t, "zap_logger_test.go", filepath.Base(e.Caller.File), e.Message)
+	}
+}
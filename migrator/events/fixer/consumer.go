@@ -37,7 +37,7 @@ func NewConsumer[T migrator.Entity](
 	}
 	return &Consumer[T]{
 		client:   client,
-		l:        l,
+		l:        l.Named("fixer"),
 		srcFirst: srcFirst,
 		dstFirst: dstFirst,
 		topic:    topic,
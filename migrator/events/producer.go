@@ -2,7 +2,7 @@ package events
 
 import (
 	"context"
-	"encoding/json"
+	"github.com/DaHuangQwQ/gpkg/saramax"
 	"github.com/IBM/sarama"
 )
 
@@ -11,22 +11,15 @@ type Producer interface {
 }
 
 type SaramaProducer struct {
-	p     sarama.SyncProducer
-	topic string
+	p *saramax.Producer[InconsistentEvent]
 }
 
 func NewSaramaProducer(topic string, p sarama.SyncProducer) *SaramaProducer {
 	return &SaramaProducer{
-		topic: topic,
-		p:     p,
+		p: saramax.NewSyncProducer[InconsistentEvent](topic, p),
 	}
 }
 
 func (s *SaramaProducer) ProduceInconsistentEvent(ctx context.Context, evt InconsistentEvent) error {
-	val, _ := json.Marshal(evt)
-	_, _, err := s.p.SendMessage(&sarama.ProducerMessage{
-		Topic: s.topic,
-		Value: sarama.ByteEncoder(val),
-	})
-	return err
+	return s.p.Produce(ctx, evt)
 }
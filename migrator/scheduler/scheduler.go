@@ -37,7 +37,7 @@ func NewScheduler[T migrator.Entity](
 	pool *connpool.DoubleWritePool,
 	producer events.Producer) *Scheduler[T] {
 	return &Scheduler[T]{
-		l:       l,
+		l:       l.Named("migrator"),
 		src:     src,
 		dst:     dst,
 		pattern: connpool.PatternSrcOnly,
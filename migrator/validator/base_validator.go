@@ -34,6 +34,6 @@ func (v *baseValidator) notify(id int64, typ string) {
 	err := v.producer.ProduceInconsistentEvent(ctx, evt)
 	if err != nil {
 		v.l.Error("发送消息失败", logger.Error(err),
-			logger.Field{Key: "event", Val: evt})
+			logger.Any("event", evt))
 	}
 }
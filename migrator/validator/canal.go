@@ -24,7 +24,7 @@ func NewCanalIncrValidator[T migrator.Entity](
 			base:      base,
 			target:    target,
 			direction: direction,
-			l:         l,
+			l:         l.Named("validator").With(logger.String("direction", direction)),
 			producer:  producer,
 		},
 	}
@@ -34,22 +34,22 @@ func NewCanalIncrValidator[T migrator.Entity](
 func (v *CanalIncrValidator[T]) Validate(ctx context.Context, id int64) error {
 	var base T
 
-	err := v.base.WithContext(ctx).Where("id = ?").First(&base).Error
+	err := v.base.WithContext(ctx).Where("id = ?", id).First(&base).Error
 	switch err {
 	case gorm.ErrRecordNotFound:
 		var target T
-		err1 := v.target.WithContext(ctx).Where("id = ?").First(&target).Error
+		err1 := v.target.WithContext(ctx).Where("id = ?", id).First(&target).Error
 		switch err1 {
 		case gorm.ErrRecordNotFound:
 			// 数据一致
 		case nil:
 			v.notify(id, events2.InconsistentEventTypeBaseMissing)
 		default:
-			return err
+			return err1
 		}
 	case nil:
 		var target T
-		err1 := v.target.WithContext(ctx).Where("id = ?").First(&target).Error
+		err1 := v.target.WithContext(ctx).Where("id = ?", id).First(&target).Error
 		switch err1 {
 		case gorm.ErrRecordNotFound:
 			v.notify(id, events2.InconsistentEventTypeTargetMissing)
@@ -58,7 +58,7 @@ func (v *CanalIncrValidator[T]) Validate(ctx context.Context, id int64) error {
 				v.notify(id, events2.InconsistentEventTypeNotEqual)
 			}
 		default:
-			return err
+			return err1
 		}
 	default:
 		return err
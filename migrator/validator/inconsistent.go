@@ -30,7 +30,7 @@ func NewValidator[T migrator.Entity](base *gorm.DB, target *gorm.DB, direction s
 		target:        target,
 		direction:     direction,
 		producer:      p,
-		l:             l,
+		l:             l.Named("validator").With(logger.String("direction", direction)),
 		batchSize:     100,
 		sleepInterval: 0,
 	}
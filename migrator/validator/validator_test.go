@@ -0,0 +1,177 @@
+package validator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/DaHuangQwQ/gpkg/logger/loggertest"
+	"github.com/DaHuangQwQ/gpkg/migrator"
+	"github.com/DaHuangQwQ/gpkg/migrator/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type User struct {
+	Id   int64
+	Name string
+}
+
+func (u User) ID() int64 {
+	return u.Id
+}
+
+func (u User) CompareTo(dst migrator.Entity) bool {
+	val, ok := dst.(User)
+	return ok && u == val
+}
+
+type producerFunc func(ctx context.Context, evt events.InconsistentEvent) error
+
+func (f producerFunc) ProduceInconsistentEvent(ctx context.Context, evt events.InconsistentEvent) error {
+	return f(ctx, evt)
+}
+
+func TestCanalIncrValidator_Validate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mock     func(base, target sqlmock.Sqlmock)
+		wantErr  error
+		wantType string
+	}{
+		{
+			name: "target 缺数据，发送消息失败",
+			mock: func(base, target sqlmock.Sqlmock) {
+				base.ExpectQuery("SELECT .* FROM `users`").WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Tom"))
+				target.ExpectQuery("SELECT .* FROM `users`").WithArgs(1, 1).
+					WillReturnError(gorm.ErrRecordNotFound)
+			},
+			wantType: events.InconsistentEventTypeTargetMissing,
+		},
+		{
+			name: "数据不一致，发送消息失败",
+			mock: func(base, target sqlmock.Sqlmock) {
+				base.ExpectQuery("SELECT .* FROM `users`").WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Tom"))
+				target.ExpectQuery("SELECT .* FROM `users`").WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Jerry"))
+			},
+			wantType: events.InconsistentEventTypeNotEqual,
+		},
+		{
+			name: "查询 target 失败",
+			mock: func(base, target sqlmock.Sqlmock) {
+				base.ExpectQuery("SELECT .* FROM `users`").WithArgs(1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Tom"))
+				target.ExpectQuery("SELECT .* FROM `users`").WithArgs(1, 1).
+					WillReturnError(sql.ErrConnDone)
+			},
+			wantErr: sql.ErrConnDone,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			base, baseMock := newDB(t)
+			target, targetMock := newDB(t)
+			tc.mock(baseMock, targetMock)
+			l := loggertest.NewLogger()
+			produceErr := errors.New("kafka error")
+			v := NewCanalIncrValidator[User](base, target, "SRC", l,
+				producerFunc(func(ctx context.Context, evt events.InconsistentEvent) error {
+					return produceErr
+				}))
+
+			err := v.Validate(context.Background(), 1)
+			assert.ErrorIs(t, err, tc.wantErr)
+			if tc.wantErr != nil {
+				assert.Equal(t, 0, l.Len())
+				return
+			}
+			l.AssertLogged(t, logger.ErrorLevel, "发送消息失败",
+				logger.String("direction", "SRC"),
+				logger.Error(produceErr),
+				logger.Any("event", events.InconsistentEvent{ID: 1, Direction: "SRC", Type: tc.wantType}))
+			assert.NoError(t, baseMock.ExpectationsWereMet())
+			assert.NoError(t, targetMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestValidator_validateTargetToBase(t *testing.T) {
+	testCases := []struct {
+		name    string
+		mock    func(base, target sqlmock.Sqlmock)
+		produce error
+		// 期望的日志
+		msg    string
+		fields []logger.Field
+	}{
+		{
+			name: "查询 base 失败",
+			mock: func(base, target sqlmock.Sqlmock) {
+				target.ExpectQuery("SELECT `id` FROM `users`").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+				base.ExpectQuery("SELECT .* FROM `users` WHERE id IN").
+					WillReturnError(sql.ErrConnDone)
+				// 重试的时候 target 没有数据了，结束
+				target.ExpectQuery("SELECT `id` FROM `users`").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			msg:    "查询 base 数据库失败",
+			fields: []logger.Field{logger.Error(sql.ErrConnDone)},
+		},
+		{
+			name: "base 缺数据，发送消息失败",
+			mock: func(base, target sqlmock.Sqlmock) {
+				target.ExpectQuery("SELECT `id` FROM `users`").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+				base.ExpectQuery("SELECT .* FROM `users` WHERE id IN").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Tom"))
+			},
+			produce: errors.New("kafka error"),
+			msg:     "发送不一致消息失败",
+			fields: []logger.Field{
+				logger.String("direction", "DST"),
+				logger.String("type", events.InconsistentEventTypeBaseMissing),
+				logger.Int64("id", 2),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			base, baseMock := newDB(t)
+			target, targetMock := newDB(t)
+			tc.mock(baseMock, targetMock)
+			l := loggertest.NewLogger()
+			v := NewValidator[User](base, target, "DST", l,
+				producerFunc(func(ctx context.Context, evt events.InconsistentEvent) error {
+					return tc.produce
+				}))
+
+			v.validateTargetToBase(context.Background())
+			l.AssertLogged(t, logger.ErrorLevel, tc.msg, tc.fields...)
+			assert.Len(t, l.FilterLevel(logger.ErrorLevel), 1)
+			assert.Equal(t, "validator", l.All()[0].Name)
+			assert.NoError(t, baseMock.ExpectationsWereMet())
+			assert.NoError(t, targetMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func newDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      sqlDB,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	return db, mock
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bbrLimiter 自适应限流，参考 TCP BBR
+// 系统能承受的在途请求数 = 窗口内单个桶的最大通过数 * 最小响应时间 / 桶的时长
+// 在途请求超过这个值，说明请求开始排队了，也就是系统已经饱和
+type bbrLimiter struct {
+	inflight    int64
+	minInflight int64
+
+	bucketDuration time.Duration
+	buckets        []bbrBucket
+	clock          Clock
+	mutex          sync.Mutex
+}
+
+type bbrBucket struct {
+	// 这个桶的起始时间，过期的桶不参与统计
+	start int64
+	// 处理完的请求数
+	pass  int64
+	rtSum time.Duration
+}
+
+// NewBBRLimiter 自适应限流，放行的请求必须通过 Acquire 拿到 DoneFunc，处理完之后调用
+func NewBBRLimiter(opts ...Option) AcquireLimiter {
+	o := newOptions(opts)
+	return &bbrLimiter{
+		minInflight:    o.minInflight,
+		bucketDuration: o.window / time.Duration(o.buckets),
+		buckets:        make([]bbrBucket, o.buckets),
+		clock:          o.clock,
+	}
+}
+
+// Limit 只判断现在会不会被限流，不会增加在途请求数，也没有办法统计响应时间
+// 注意：只调用 Limit 的话在途请求数一直是 0，永远不会限流，必须通过 Acquire 使用
+func (b *bbrLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	if b.shouldDrop(b.clock.Now()) {
+		return true, ErrLimitExceeded
+	}
+	return false, nil
+}
+
+func (b *bbrLimiter) Acquire(ctx context.Context, key string) (DoneFunc, error) {
+	start := b.clock.Now()
+	if b.shouldDrop(start) {
+		return nil, ErrLimitExceeded
+	}
+	atomic.AddInt64(&b.inflight, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&b.inflight, -1)
+			now := b.clock.Now()
+			b.record(now, now.Sub(start))
+		})
+	}, nil
+}
+
+func (b *bbrLimiter) shouldDrop(now time.Time) bool {
+	inflight := atomic.LoadInt64(&b.inflight)
+	if inflight < b.minInflight {
+		return false
+	}
+	return inflight > b.maxInflight(now)
+}
+
+// maxInflight 没有统计数据的时候不限流
+func (b *bbrLimiter) maxInflight(now time.Time) int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cur := b.bucketStart(now)
+	boundary := cur - int64(b.bucketDuration)*int64(len(b.buckets))
+	var maxPass int64
+	minRT := time.Duration(math.MaxInt64)
+	for _, bucket := range b.buckets {
+		// 当前桶还没统计完，跳过
+		if bucket.start <= boundary || bucket.start >= cur || bucket.pass == 0 {
+			continue
+		}
+		maxPass = max(maxPass, bucket.pass)
+		minRT = min(minRT, bucket.rtSum/time.Duration(bucket.pass))
+	}
+	if maxPass == 0 {
+		return math.MaxInt64
+	}
+	return int64(math.Ceil(float64(maxPass) * float64(minRT) / float64(b.bucketDuration)))
+}
+
+func (b *bbrLimiter) record(now time.Time, rt time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	start := b.bucketStart(now)
+	bucket := &b.buckets[(start/int64(b.bucketDuration))%int64(len(b.buckets))]
+	if bucket.start != start {
+		// 上一轮的桶，重置
+		*bucket = bbrBucket{start: start}
+	}
+	bucket.pass++
+	bucket.rtSum += rt
+}
+
+func (b *bbrLimiter) bucketStart(now time.Time) int64 {
+	ns := now.UnixNano()
+	return ns - ns%int64(b.bucketDuration)
+}
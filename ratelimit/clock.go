@@ -0,0 +1,28 @@
+package ratelimit
+
+import "time"
+
+// Clock 限流器获取当前时间的方式，测试的时候可以换成手动推进的时钟
+type Clock interface {
+	Now() time.Time
+}
+
+// tickerClock 需要定时执行任务的限流器会用 Clock 创建 ticker，保证只有一个时间来源
+// 没有实现的 Clock 使用系统的 ticker，例如 ratelimittest.ManualClock 实现了它
+type tickerClock interface {
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+func newTicker(clock Clock, d time.Duration) (<-chan time.Time, func()) {
+	if tc, ok := clock.(tickerClock); ok {
+		return tc.NewTicker(d)
+	}
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
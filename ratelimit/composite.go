@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Refunder 可以把已经拿到的配额还回去
+type Refunder interface {
+	Refund(ctx context.Context, key string, n int64) error
+}
+
+// KeyFunc 从原始的 key 推导出这一层的限流对象，例如 用户 -> 租户
+type KeyFunc func(ctx context.Context, key string) string
+
+// StaticKey 全局限流，所有请求共享一个 key
+func StaticKey(key string) KeyFunc {
+	return func(ctx context.Context, _ string) string {
+		return key
+	}
+}
+
+// PrefixKey 给原始的 key 加上前缀，避免不同层在 redis 上冲突
+func PrefixKey(prefix string) KeyFunc {
+	return func(ctx context.Context, key string) string {
+		return prefix + key
+	}
+}
+
+// Stage 组合限流中的一层
+type Stage struct {
+	Limiter Limiter
+	// 为 nil 就直接用原始的 key
+	Key KeyFunc
+}
+
+// compositeLimiter 按顺序判断每一层，有一层被限流就不再往下判断
+// 前面已经拿到的配额会还回去，前提是那一层实现了 Refunder
+type compositeLimiter struct {
+	stages []Stage
+}
+
+// NewCompositeLimiter 例如 每个用户 100/s 并且 每个租户 10k/s 并且 全局 1M/min
+func NewCompositeLimiter(stages ...Stage) Limiter {
+	return &compositeLimiter{
+		stages: stages,
+	}
+}
+
+func (c *compositeLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(c.Allow(ctx, key))
+}
+
+func (c *compositeLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return c.AllowN(ctx, key, 1)
+}
+
+func (c *compositeLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	var res Result
+	for i, stage := range c.stages {
+		r, err := AllowN(ctx, stage.Limiter, c.key(ctx, stage, key), n)
+		if err != nil || !r.Allowed {
+			c.refund(ctx, key, c.stages[:i], n)
+			return r, err
+		}
+		res = merge(res, r, i == 0)
+	}
+	res.Allowed = true
+	return res, nil
+}
+
+// Refund 每一层都还回去
+func (c *compositeLimiter) Refund(ctx context.Context, key string, n int64) error {
+	c.refund(ctx, key, c.stages, n)
+	return nil
+}
+
+// refund 尽力而为，还失败了也只是少放行了一点请求
+func (c *compositeLimiter) refund(ctx context.Context, key string, stages []Stage, n int64) {
+	for _, stage := range stages {
+		if r, ok := stage.Limiter.(Refunder); ok {
+			_ = r.Refund(ctx, c.key(ctx, stage, key), n)
+		}
+	}
+}
+
+func (c *compositeLimiter) key(ctx context.Context, stage Stage, key string) string {
+	if stage.Key == nil {
+		return key
+	}
+	return stage.Key(ctx, key)
+}
+
+// merge 取最严格的那一层
+func merge(res Result, r Result, first bool) Result {
+	if first || r.Remaining < res.Remaining {
+		res.Limit = r.Limit
+		res.Remaining = r.Remaining
+	}
+	if r.ResetAt.After(res.ResetAt) {
+		res.ResetAt = r.ResetAt
+	}
+	res.RetryAfter = max(res.RetryAfter, r.RetryAfter, time.Duration(0))
+	return res
+}
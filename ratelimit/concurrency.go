@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// concurrencyLimiter 并发限流，每个 key 一个信号量，限制的是在途请求数而不是到达速率
+type concurrencyLimiter struct {
+	capacity int64
+	shards   []*concurrencyShard
+}
+
+type concurrencyShard struct {
+	mutex    sync.Mutex
+	inflight map[string]int64
+}
+
+// NewConcurrencyLimiter 每个 key 最多 capacity 个在途请求，放行的请求必须通过 Acquire 拿到 DoneFunc，处理完之后调用
+// 和 redis 的实现一样，capacity 必须大于 0
+func NewConcurrencyLimiter(capacity int64, opts ...Option) (AcquireLimiter, error) {
+	if capacity < 1 {
+		return nil, fmt.Errorf("ratelimit: capacity 必须大于 0，实际是 %d", capacity)
+	}
+	o := newOptions(opts)
+	shards := make([]*concurrencyShard, o.shards)
+	for i := range shards {
+		shards[i] = &concurrencyShard{
+			inflight: make(map[string]int64),
+		}
+	}
+	return &concurrencyLimiter{
+		capacity: capacity,
+		shards:   shards,
+	}, nil
+}
+
+// Limit 只判断现在有没有空闲的信号量，不会占用
+// 注意：只调用 Limit 的话信号量永远不会被占用，也就永远不会限流，必须通过 Acquire 使用
+func (c *concurrencyLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	shard := c.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if shard.inflight[key] >= c.capacity {
+		return true, ErrLimitExceeded
+	}
+	return false, nil
+}
+
+func (c *concurrencyLimiter) Acquire(ctx context.Context, key string) (DoneFunc, error) {
+	shard := c.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if shard.inflight[key] >= c.capacity {
+		return nil, ErrLimitExceeded
+	}
+	shard.inflight[key]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			shard.mutex.Lock()
+			defer shard.mutex.Unlock()
+			shard.inflight[key]--
+			if shard.inflight[key] <= 0 {
+				// 没有在途请求了，删掉避免 key 越来越多
+				delete(shard.inflight, key)
+			}
+		})
+	}, nil
+}
+
+func (c *concurrencyLimiter) shard(key string) *concurrencyShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
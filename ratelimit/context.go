@@ -0,0 +1,27 @@
+package ratelimit
+
+import "context"
+
+type methodKey struct{}
+
+type tenantKey struct{}
+
+// ContextWithMethod 规则可以按照 gRPC 方法匹配
+func ContextWithMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, methodKey{}, method)
+}
+
+func MethodFromContext(ctx context.Context) string {
+	method, _ := ctx.Value(methodKey{}).(string)
+	return method
+}
+
+// ContextWithTenant 规则可以按照租户匹配
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
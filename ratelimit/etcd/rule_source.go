@@ -0,0 +1,52 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// RuleSource 限流规则放在 etcd 的一个 key 上，实现了 ratelimit.RuleSource
+type RuleSource struct {
+	kv      clientv3.KV
+	watcher clientv3.Watcher
+	key     string
+}
+
+func NewRuleSource(client *clientv3.Client, key string) *RuleSource {
+	return &RuleSource{
+		kv:      client,
+		watcher: client,
+		key:     key,
+	}
+}
+
+func (s *RuleSource) Watch(ctx context.Context) (<-chan []byte, error) {
+	resp, err := s.kv.Get(ctx, s.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("限流规则 %s 不存在", s.key)
+	}
+	ch := make(chan []byte, 1)
+	ch <- resp.Kvs[0].Value
+	// 从 Get 之后的版本开始监听，避免漏掉中间的变更
+	watchCh := s.watcher.Watch(ctx, s.key, clientv3.WithRev(resp.Header.Revision+1))
+	go func() {
+		defer close(ch)
+		for wresp := range watchCh {
+			for _, event := range wresp.Events {
+				if event.Type != clientv3.EventTypePut {
+					continue
+				}
+				select {
+				case ch <- event.Kv.Value:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,84 @@
+package etcd
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"testing"
+	"time"
+)
+
+func TestRuleSource_Watch(t *testing.T) {
+	watchCh := make(chan clientv3.WatchResponse)
+	w := &mockWatcher{ch: watchCh}
+	s := &RuleSource{
+		kv: &mockKV{resp: &clientv3.GetResponse{
+			Header: &etcdserverpb.ResponseHeader{Revision: 5},
+			Kvs:    []*mvccpb.KeyValue{{Key: []byte("rules"), Value: []byte("v1")}},
+		}},
+		watcher: w,
+		key:     "rules",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := s.Watch(ctx)
+	require.NoError(t, err)
+	// 先推送当前的规则
+	assert.Equal(t, []byte("v1"), <-ch)
+	// 从 Get 之后的版本开始监听
+	assert.Equal(t, "rules", w.key)
+	assert.Equal(t, int64(6), w.rev)
+
+	watchCh <- clientv3.WatchResponse{Events: []*clientv3.Event{
+		{Type: clientv3.EventTypeDelete, Kv: &mvccpb.KeyValue{Key: []byte("rules")}},
+		{Type: clientv3.EventTypePut, Kv: &mvccpb.KeyValue{Key: []byte("rules"), Value: []byte("v2")}},
+	}}
+	// 删除会被忽略，继续使用原来的规则
+	assert.Equal(t, []byte("v2"), <-ch)
+
+	// watch 结束之后关闭 channel
+	close(watchCh)
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("channel 没有关闭")
+	}
+}
+
+func TestRuleSource_WatchNotFound(t *testing.T) {
+	s := &RuleSource{
+		kv: &mockKV{resp: &clientv3.GetResponse{
+			Header: &etcdserverpb.ResponseHeader{Revision: 5},
+		}},
+		watcher: &mockWatcher{},
+		key:     "rules",
+	}
+	_, err := s.Watch(context.Background())
+	assert.EqualError(t, err, "限流规则 rules 不存在")
+}
+
+type mockKV struct {
+	clientv3.KV
+	resp *clientv3.GetResponse
+}
+
+func (m *mockKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	return m.resp, nil
+}
+
+type mockWatcher struct {
+	clientv3.Watcher
+	ch  chan clientv3.WatchResponse
+	key string
+	rev int64
+}
+
+func (m *mockWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	m.key = key
+	m.rev = clientv3.OpGet(key, opts...).Rev()
+	return m.ch
+}
@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// FallbackLimiter redis 之类的远程限流器不可用的时候，降级成本地限流
+// 连续失败 errorThreshold 次才会降级，还没降级的时候，失败的请求由本地限流器判断
+// 降级之后每隔 probeInterval 会放一个请求去试探远程限流器，连续成功 recoverThreshold 次才切回去
+type FallbackLimiter struct {
+	remote Limiter
+	// 按照实例数量创建本地限流器，一般是把全局的阈值除以实例数量
+	newLocal func(replicas int) Limiter
+	// 当前的实例数量
+	replicas         func() int
+	probeInterval    time.Duration
+	errorThreshold   int
+	recoverThreshold int
+
+	clock     Clock
+	mutex     sync.Mutex
+	local     Limiter
+	fallback  bool
+	lastProbe time.Time
+	// 连续失败的次数
+	errCnt int
+	// 降级之后连续试探成功的次数
+	probeCnt int
+
+	gauge prometheus.Gauge
+}
+
+// NewFallbackLimiter 例如
+//
+//	NewFallbackLimiter(redisLimiter, func(replicas int) Limiter {
+//		return NewGCRALimiter(time.Second, int64(1000/replicas), int64(1000/replicas))
+//	}, func() int { return 3 })
+//
+// 默认连续失败 3 次降级，每秒试探一次，连续试探成功 3 次恢复
+func NewFallbackLimiter(remote Limiter, newLocal func(replicas int) Limiter, replicas func() int) *FallbackLimiter {
+	return &FallbackLimiter{
+		remote:           remote,
+		newLocal:         newLocal,
+		replicas:         replicas,
+		probeInterval:    time.Second,
+		errorThreshold:   3,
+		recoverThreshold: 3,
+		clock:            systemClock{},
+	}
+}
+
+// Clock 替换获取当前时间的方式，一般是测试的时候用
+func (f *FallbackLimiter) Clock(clock Clock) *FallbackLimiter {
+	f.clock = clock
+	return f
+}
+
+// ProbeInterval 降级之后多久试探一次远程限流器
+func (f *FallbackLimiter) ProbeInterval(interval time.Duration) *FallbackLimiter {
+	f.probeInterval = interval
+	return f
+}
+
+// ErrorThreshold 远程限流器连续失败多少次之后降级
+func (f *FallbackLimiter) ErrorThreshold(n int) *FallbackLimiter {
+	if n > 0 {
+		f.errorThreshold = n
+	}
+	return f
+}
+
+// RecoverThreshold 降级之后连续试探成功多少次才切回远程限流器
+func (f *FallbackLimiter) RecoverThreshold(n int) *FallbackLimiter {
+	if n > 0 {
+		f.recoverThreshold = n
+	}
+	return f
+}
+
+// Metrics 上报当前所处的模式，1 是本地限流，0 是远程限流
+func (f *FallbackLimiter) Metrics(opts prometheus.GaugeOpts) *FallbackLimiter {
+	gauge := prometheus.NewGauge(opts)
+	prometheus.MustRegister(gauge)
+	f.gauge = gauge
+	return f
+}
+
+// Fallback 当前是不是处于降级状态
+func (f *FallbackLimiter) Fallback() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.fallback
+}
+
+func (f *FallbackLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(f.Allow(ctx, key))
+}
+
+func (f *FallbackLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return f.AllowN(ctx, key, 1)
+}
+
+func (f *FallbackLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	local, probe := f.route()
+	if local != nil {
+		return AllowN(ctx, local, key, n)
+	}
+	res, err := AllowN(ctx, f.remote, key, n)
+	if err == nil {
+		f.succeed(probe)
+		return res, nil
+	}
+	if ctx.Err() != nil || errors.Is(err, ErrInvalidPermits) || errors.Is(err, ErrPermitsNotSupported) {
+		// 调用者自己超时了，或者参数不对，不是远程限流器的问题
+		return res, err
+	}
+	return AllowN(ctx, f.fail(), key, n)
+}
+
+// route 处于降级状态并且还没到试探的时候，返回本地限流器
+// 到了试探的时候只放一个请求去远程限流器，probe 为 true
+func (f *FallbackLimiter) route() (local Limiter, probe bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.fallback {
+		return nil, false
+	}
+	now := f.clock.Now()
+	if now.Sub(f.lastProbe) < f.probeInterval {
+		return f.local, false
+	}
+	f.lastProbe = now
+	return nil, true
+}
+
+// succeed 只有试探的请求才会让降级的限流器恢复
+// 降级之前就发出去的请求，成功了也不算数
+func (f *FallbackLimiter) succeed(probe bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.fallback {
+		f.errCnt = 0
+		return
+	}
+	if !probe {
+		return
+	}
+	f.probeCnt++
+	if f.probeCnt >= f.recoverThreshold {
+		f.fallback = false
+		f.errCnt = 0
+		// 下一次降级的时候按照那时候的实例数量重新创建
+		f.local = nil
+		f.setGauge(0)
+	}
+}
+
+// fail 返回本地限流器，由它来判断这个请求
+func (f *FallbackLimiter) fail() Limiter {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.local == nil {
+		f.local = f.newLocal(max(f.replicas(), 1))
+	}
+	if f.fallback {
+		// 试探失败，重新开始计数
+		f.probeCnt = 0
+		return f.local
+	}
+	f.errCnt++
+	if f.errCnt >= f.errorThreshold {
+		f.fallback = true
+		f.probeCnt = 0
+		f.lastProbe = f.clock.Now()
+		f.setGauge(1)
+	}
+	return f.local
+}
+
+func (f *FallbackLimiter) setGauge(val float64) {
+	if f.gauge != nil {
+		f.gauge.Set(val)
+	}
+}
@@ -3,7 +3,6 @@ package ratelimit
 import (
 	"context"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -18,35 +17,64 @@ type fixWindowLimiter struct {
 
 	cnt int64
 
+	clock Clock
 	mutex sync.Mutex
 }
 
-func NewFixWindowLimiter(interval time.Duration, rate int64) Limiter {
+func NewFixWindowLimiter(interval time.Duration, rate int64, opts ...Option) Limiter {
+	o := newOptions(opts)
 	return &fixWindowLimiter{
-		timestamp: time.Now().UnixNano(),
+		timestamp: o.clock.Now().UnixNano(),
 		interval:  interval,
 		rate:      rate,
 		cnt:       0,
+		clock:     o.clock,
 		mutex:     sync.Mutex{},
 	}
 }
 
 func (f *fixWindowLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(f.Allow(ctx, key))
+}
+
+func (f *fixWindowLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return f.AllowN(ctx, key, 1)
+}
+
+func (f *fixWindowLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := checkPermits(n); err != nil {
+		return Result{}, err
+	}
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
-	timestamp := atomic.LoadInt64(&f.timestamp)
-	cnt := atomic.LoadInt64(&f.cnt)
-	cur := time.Now().UnixNano()
+	cur := f.clock.Now().UnixNano()
+	if f.timestamp+int64(f.interval) < cur {
+		// 进入了新的窗口
+		f.timestamp = cur
+		f.cnt = 0
+	}
 
-	if timestamp+int64(f.interval) < cur {
-		if atomic.CompareAndSwapInt64(&f.timestamp, timestamp, cur) {
-			atomic.CompareAndSwapInt64(&f.cnt, cnt, 0)
-		}
+	resetAt := time.Unix(0, f.timestamp+int64(f.interval))
+	res := Result{Limit: f.rate, ResetAt: resetAt}
+	if f.cnt+n > f.rate {
+		res.Remaining = f.rate - f.cnt
+		res.RetryAfter = resetAt.Sub(time.Unix(0, cur))
+		return res, nil
 	}
-	cnt = atomic.AddInt64(&f.cnt, 1)
-	if cnt > f.rate {
-		return true, ErrLimitExceeded
+	f.cnt += n
+	res.Allowed = true
+	res.Remaining = f.rate - f.cnt
+	return res, nil
+}
+
+// Refund 只会还到当前窗口
+func (f *fixWindowLimiter) Refund(ctx context.Context, key string, n int64) error {
+	if err := checkPermits(n); err != nil {
+		return err
 	}
-	return false, nil
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.cnt = max(f.cnt-n, 0)
+	return nil
 }
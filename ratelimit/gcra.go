@@ -0,0 +1,197 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// gcraLimiter 通用信元速率算法 (GCRA)
+// 每个 key 只记录一个理论到达时间 (TAT)，按 key 分片存储
+type gcraLimiter struct {
+	// 阈值可以在运行期间调整，每个 key 的 TAT 不受影响
+	params atomic.Pointer[gcraParams]
+
+	idleTimeout time.Duration
+	shards      []*gcraShard
+	clock       Clock
+}
+
+type gcraParams struct {
+	// 每个请求占用的时间，interval / rate
+	emission time.Duration
+	// 允许的突发，emission * burst
+	tolerance time.Duration
+	burst     int64
+}
+
+// newGCRAParams 参数不合法是编码错误，直接 panic
+// 通过规则创建的限流器由 Rule.Validate 提前校验
+func newGCRAParams(interval time.Duration, rate int64, burst int64) *gcraParams {
+	if rate < 1 {
+		panic(fmt.Sprintf("ratelimit: rate 必须大于 0，实际是 %d", rate))
+	}
+	if burst < 1 {
+		panic(fmt.Sprintf("ratelimit: burst 必须大于 0，实际是 %d", burst))
+	}
+	emission := interval / time.Duration(rate)
+	if emission < time.Microsecond {
+		// redis 的实现按照微秒计算，不足 1µs 会变成 0
+		panic(fmt.Sprintf("ratelimit: interval / rate 不能小于 1µs，实际是 %s", emission))
+	}
+	return &gcraParams{
+		emission:  emission,
+		tolerance: emission * time.Duration(burst),
+		burst:     burst,
+	}
+}
+
+type gcraShard struct {
+	mutex sync.Mutex
+	tats  map[string]time.Time
+	// 上一次清理空闲 key 的时间
+	lastSweep time.Time
+}
+
+// NewGCRALimiter interval 内最多 rate 个请求, burst 允许的最大突发请求数
+// rate、burst 必须大于 0，interval / rate 不能小于 1µs，否则 panic
+func NewGCRALimiter(interval time.Duration, rate int64, burst int64, opts ...Option) Limiter {
+	o := newOptions(opts)
+	shards := make([]*gcraShard, o.shards)
+	now := o.clock.Now()
+	for i := range shards {
+		shards[i] = &gcraShard{
+			tats:      make(map[string]time.Time),
+			lastSweep: now,
+		}
+	}
+	res := &gcraLimiter{
+		idleTimeout: o.idleTimeout,
+		shards:      shards,
+		clock:       o.clock,
+	}
+	res.params.Store(newGCRAParams(interval, rate, burst))
+	return res
+}
+
+func (g *gcraLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(g.Allow(ctx, key))
+}
+
+func (g *gcraLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return g.AllowN(ctx, key, 1)
+}
+
+func (g *gcraLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := checkPermits(n); err != nil {
+		return Result{}, err
+	}
+	p := g.params.Load()
+	shard := g.shard(key)
+	now := g.clock.Now()
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	g.sweep(shard, now)
+
+	tat := shard.tat(key, now)
+	newTat := tat.Add(p.emission * time.Duration(n))
+	// 最早可以放行的时间
+	allowAt := newTat.Add(-p.tolerance)
+	if now.Before(allowAt) {
+		return Result{
+			Limit:      p.burst,
+			Remaining:  max(int64(now.Sub(tat.Add(-p.tolerance))/p.emission), 0),
+			ResetAt:    tat,
+			RetryAfter: allowAt.Sub(now),
+		}, nil
+	}
+	shard.tats[key] = newTat
+	return Result{
+		Allowed:   true,
+		Limit:     p.burst,
+		Remaining: int64(now.Sub(allowAt) / p.emission),
+		ResetAt:   newTat,
+	}, nil
+}
+
+// Reserve 直接把 TAT 往后推，需要等的时间就是 TAT 超出容忍度的部分
+func (g *gcraLimiter) Reserve(ctx context.Context, key string, n int64) (*Reservation, error) {
+	if err := checkPermits(n); err != nil {
+		return nil, err
+	}
+	p := g.params.Load()
+	if n > p.burst {
+		// 永远不可能凑够
+		return &Reservation{}, nil
+	}
+	shard := g.shard(key)
+	now := g.clock.Now()
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	g.sweep(shard, now)
+
+	cost := p.emission * time.Duration(n)
+	newTat := shard.tat(key, now).Add(cost)
+	shard.tats[key] = newTat
+	timeToAct := newTat.Add(-p.tolerance)
+	if timeToAct.Before(now) {
+		timeToAct = now
+	}
+	return newReservation(g.clock, timeToAct, func() {
+		shard.mutex.Lock()
+		defer shard.mutex.Unlock()
+		if tat, ok := shard.tats[key]; ok {
+			shard.tats[key] = tat.Add(-cost)
+		}
+	}), nil
+}
+
+// Refund 把 TAT 往回拨
+func (g *gcraLimiter) Refund(ctx context.Context, key string, n int64) error {
+	if err := checkPermits(n); err != nil {
+		return err
+	}
+	shard := g.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if tat, ok := shard.tats[key]; ok {
+		shard.tats[key] = tat.Add(-g.params.Load().emission * time.Duration(n))
+	}
+	return nil
+}
+
+func (g *gcraLimiter) shard(key string) *gcraShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return g.shards[h.Sum32()%uint32(len(g.shards))]
+}
+
+// sweep TAT 已经过去的 key 和没有记录是等价的，空闲久了就删掉
+func (g *gcraLimiter) sweep(shard *gcraShard, now time.Time) {
+	if now.Sub(shard.lastSweep) < g.idleTimeout {
+		return
+	}
+	shard.lastSweep = now
+	boundary := now.Add(-g.idleTimeout)
+	for key, tat := range shard.tats {
+		if tat.Before(boundary) {
+			delete(shard.tats, key)
+		}
+	}
+}
+
+// tat TAT 已经过去了就从现在开始算
+func (s *gcraShard) tat(key string, now time.Time) time.Time {
+	tat, ok := s.tats[key]
+	if !ok || tat.Before(now) {
+		return now
+	}
+	return tat
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// keyedLimiter 固定窗口、滑动窗口和令牌桶只有一份状态，规则里面每个 key 单独创建一个
+// 空闲超过 ttl 的 key 会被清理，清理掉的 key 和新的 key 没有区别
+type keyedLimiter struct {
+	rule Rule
+	// 每次 withRule 加一，已经创建的限流器在下一次使用的时候调整成新的阈值
+	version uint64
+	newFunc func(rule Rule) Limiter
+	ttl     time.Duration
+	shards  []*keyedShard
+	clock   Clock
+}
+
+type keyedShard struct {
+	mutex sync.Mutex
+	items map[string]*keyedItem
+	// 上一次清理空闲 key 的时间
+	lastSweep time.Time
+}
+
+type keyedItem struct {
+	limiter Limiter
+	version uint64
+	// 上一次使用的时间
+	last time.Time
+}
+
+func newKeyedLimiter(rule Rule, newFunc func(rule Rule) Limiter, opts []Option) *keyedLimiter {
+	o := newOptions(opts)
+	shards := make([]*keyedShard, o.shards)
+	now := o.clock.Now()
+	for i := range shards {
+		shards[i] = &keyedShard{
+			items:     make(map[string]*keyedItem),
+			lastSweep: now,
+		}
+	}
+	return &keyedLimiter{
+		rule:    rule,
+		newFunc: newFunc,
+		ttl:     max(o.idleTimeout, rule.ttl()),
+		shards:  shards,
+		clock:   o.clock,
+	}
+}
+
+func (k *keyedLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(k.Allow(ctx, key))
+}
+
+func (k *keyedLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return k.AllowN(ctx, key, 1)
+}
+
+func (k *keyedLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	return AllowN(ctx, k.limiter(key), key, n)
+}
+
+// withRule 和原本的限流器共用每个 key 的状态
+func (k *keyedLimiter) withRule(rule Rule) Limiter {
+	res := *k
+	res.rule = rule
+	res.version = k.version + 1
+	res.ttl = max(k.ttl, rule.ttl())
+	return &res
+}
+
+func (k *keyedLimiter) limiter(key string) Limiter {
+	shard := k.shard(key)
+	now := k.clock.Now()
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	k.sweep(shard, now)
+
+	item, ok := shard.items[key]
+	if !ok {
+		item = &keyedItem{limiter: k.newFunc(k.rule), version: k.version}
+		shard.items[key] = item
+	}
+	// 旧的规则还在处理的请求不会把阈值改回去
+	if item.version < k.version {
+		item.limiter.(reconfigurable).reconfigure(k.rule)
+		item.version = k.version
+	}
+	item.last = now
+	return item.limiter
+}
+
+func (k *keyedLimiter) shard(key string) *keyedShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return k.shards[h.Sum32()%uint32(len(k.shards))]
+}
+
+// sweep 超过 ttl 没有使用的 key，状态已经恢复成初始值了，删掉也没有影响
+func (k *keyedLimiter) sweep(shard *keyedShard, now time.Time) {
+	if now.Sub(shard.lastSweep) < k.ttl {
+		return
+	}
+	shard.lastSweep = now
+	boundary := now.Add(-k.ttl)
+	for key, item := range shard.items {
+		if item.last.Before(boundary) {
+			delete(shard.items, key)
+		}
+	}
+}
@@ -2,30 +2,55 @@ package ratelimit
 
 import (
 	"context"
+	"fmt"
 	"time"
 )
 
 // leakyBucketLimiter 漏桶
 type leakyBucketLimiter struct {
-	ticker *time.Ticker
+	tick <-chan time.Time
+	stop func()
 }
 
-func NewLeakyBucketLimiter(ticker *time.Ticker) Limiter {
+// NewLeakyBucketLimiter 每隔 interval 漏出一个请求，ticker 由 WithClock 传入的时钟创建
+// interval 必须大于 0，否则 panic
+func NewLeakyBucketLimiter(interval time.Duration, opts ...Option) Limiter {
+	if interval <= 0 {
+		panic(fmt.Sprintf("ratelimit: interval 必须大于 0，实际是 %s", interval))
+	}
+	o := newOptions(opts)
+	tick, stop := newTicker(o.clock, interval)
 	return &leakyBucketLimiter{
-		ticker: ticker,
+		tick: tick,
+		stop: stop,
 	}
 }
 
 func (l *leakyBucketLimiter) Limit(ctx context.Context, key string) (bool, error) {
-	select {
-	case <-ctx.Done():
-		return true, ctx.Err()
-	case <-l.ticker.C:
-		return false, nil
+	return limitOf(l.Allow(ctx, key))
+}
+
+// Allow 漏桶会一直等到漏出一个请求，所以只要没出错都是放行
+func (l *leakyBucketLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 要等漏出 n 个请求
+func (l *leakyBucketLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := checkPermits(n); err != nil {
+		return Result{}, err
+	}
+	for i := int64(0); i < n; i++ {
+		select {
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		case <-l.tick:
+		}
 	}
+	return Result{Allowed: true, Limit: 1}, nil
 }
 
 func (l *leakyBucketLimiter) Close() error {
-	l.ticker.Stop()
+	l.stop()
 	return nil
 }
@@ -1,23 +1,26 @@
+-- 返回 {是否限流, 窗口内已经用掉的配额, 窗口剩余的毫秒数}
 -- 先找找有没有这个限流对象的设置
 local val = redis.call('get', KEYS[1])
 local expiration = ARGV[1]
 local limit = tonumber(ARGV[2])
+-- 这一次要消耗的配额
+local cost = tonumber(ARGV[3])
 if val == false then
-    if limit < 1 then
+    if limit < cost then
         -- 执行限流
-        return "true"
+        return {1, 0, tonumber(expiration)}
     else
         -- set your_service 1 px 100s
-        redis.call('set', KEYS[1], 1, 'PX', expiration)
+        redis.call('set', KEYS[1], cost, 'PX', expiration)
         -- 不执行限流
-        return "false"
+        return {0, cost, tonumber(expiration)}
     end
-elseif tonumber(val) < limit then
+elseif tonumber(val) + cost <= limit then
     -- 有这个限流对象，但是还没到阈值
-    redis.call('incr', KEYS[1])
+    local cnt = redis.call('incrby', KEYS[1], cost)
     -- 不指定限流
-    return "false"
+    return {0, cnt, redis.call('pttl', KEYS[1])}
 else
     -- 执行限流
-    return "true"
-end
\ No newline at end of file
+    return {1, tonumber(val), redis.call('pttl', KEYS[1])}
+end
@@ -0,0 +1,29 @@
+-- 返回 {是否限流, 剩余的突发配额, 多少微秒之后可以重试, 多少微秒之后完全恢复}
+-- 限流对象
+local key = KEYS[1]
+-- 每个请求占用的时间，单位微秒
+local emission = tonumber(ARGV[1])
+-- 允许的突发
+local tolerance = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+-- 这一次要消耗的配额
+local cost = tonumber(ARGV[4])
+
+-- 理论到达时间
+local tat = tonumber(redis.call('GET', key))
+if tat == nil or tat < now then
+    tat = now
+end
+
+local newTat = tat + emission * cost
+-- 最早可以放行的时间
+local allowAt = newTat - tolerance
+if now < allowAt then
+    -- 执行限流
+    return {1, math.max(math.floor((now - tat + tolerance) / emission), 0), allowAt - now, tat - now}
+else
+    -- TAT 过去之后，这个 key 就没用了
+    local ttl = math.ceil((newTat - now) / 1000)
+    redis.call('SET', key, string.format('%d', newTat), 'PX', ttl)
+    return {0, math.floor((now - allowAt) / emission), 0, newTat - now}
+end
@@ -0,0 +1,18 @@
+-- 把 TAT 往回拨
+local key = KEYS[1]
+-- 要还回去的时间，单位微秒
+local cost = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call('GET', key))
+if tat == nil then
+    return 0
+end
+tat = tat - cost
+if tat <= now then
+    -- 已经完全恢复了
+    redis.call('DEL', key)
+else
+    redis.call('SET', key, string.format('%d', tat), 'KEEPTTL')
+end
+return 1
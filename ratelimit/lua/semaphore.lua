@@ -0,0 +1,21 @@
+-- 信号量，每个持有者是 zset 里面的一个 member，score 是租约的过期时间
+-- 返回 1 是拿到了信号量，0 是没有拿到
+local key = KEYS[1]
+-- 最多多少个持有者
+local capacity = tonumber(ARGV[1])
+-- 租约时长，单位毫秒
+local lease = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+-- 持有者的唯一标识
+local id = ARGV[4]
+
+-- 崩溃的持有者不会释放，等租约过期之后清理掉
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+local cnt = redis.call('ZCARD', key)
+if cnt >= capacity then
+    -- 执行限流
+    return 0
+end
+redis.call('ZADD', key, now + lease, id)
+redis.call('PEXPIRE', key, lease)
+return 1
@@ -1,6 +1,7 @@
 -- 1, 2, 3, 4, 5, 6, 7 这是你的元素
 -- ZREMRANGEBYSCORE key1 0 6
 -- 7 执行完之后
+-- 返回 {是否限流, 窗口内的请求数, 多少毫秒之后可以重试, 多少毫秒之后窗口清空}
 
 -- 限流对象
 local key = KEYS[1]
@@ -9,18 +10,31 @@ local window = tonumber(ARGV[1])
 -- 阈值
 local threshold = tonumber( ARGV[2])
 local now = tonumber(ARGV[3])
+-- 这一次要消耗的配额，每个配额一个 member
+local cost = tonumber(ARGV[4])
+-- 这一次请求的唯一标识，避免同一毫秒的请求 member 相同
+local id = ARGV[5]
 -- 窗口的起始时间
 local min = now - window
 
 redis.call('ZREMRANGEBYSCORE', key, '-inf', min)
 local cnt = redis.call('ZCOUNT', key, '-inf', '+inf')
 -- local cnt = redis.call('ZCOUNT', key, min, '+inf')
-if cnt >= threshold then
+if cnt + cost > threshold then
     -- 执行限流
-    return "true"
+    -- 前面的请求滑出窗口，腾出足够的配额之后就可以重试
+    local retry = window
+    local need = cnt + cost - threshold
+    local oldest = redis.call('ZRANGE', key, need - 1, need - 1, 'WITHSCORES')
+    if oldest[2] ~= nil then
+        retry = tonumber(oldest[2]) + window - now
+    end
+    return {1, cnt, retry, redis.call('PTTL', key)}
 else
-    -- 把 score 和 member 都设置成 now
-    redis.call('ZADD', key, now, now)
+    -- score 设置成 now
+    for i = 1, cost do
+        redis.call('ZADD', key, now, id .. ':' .. i)
+    end
     redis.call('PEXPIRE', key, window)
-    return "false"
-end
\ No newline at end of file
+    return {0, cnt + cost, 0, window}
+end
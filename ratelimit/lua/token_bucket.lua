@@ -0,0 +1,47 @@
+-- 返回 {是否限流, 剩余令牌数, 多少微秒之后可以重试, 多少微秒之后令牌桶装满}
+-- 限流对象
+local key = KEYS[1]
+-- 多久产生一个令牌，单位微秒
+local interval = tonumber(ARGV[1])
+-- 令牌桶容量
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+-- 这一次要消耗的令牌数
+local cost = tonumber(ARGV[4])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+-- 上一次补充令牌的时间
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+    -- 新的限流对象，令牌桶是满的
+    tokens = capacity
+    ts = now
+end
+
+-- 按照时间差惰性补充令牌
+local delta = math.floor((now - ts) / interval)
+if delta > 0 then
+    tokens = math.min(capacity, tokens + delta)
+    ts = ts + delta * interval
+end
+if tokens >= capacity then
+    -- 满了就不再累积
+    ts = now
+end
+
+local limited = 1
+local retry = 0
+if tokens >= cost then
+    tokens = tokens - cost
+    limited = 0
+else
+    -- 执行限流，等令牌凑够
+    retry = ts + (cost - tokens) * interval - now
+end
+
+-- 装满之后这个 key 就没用了
+local full = (capacity - tokens) * interval - (now - ts)
+redis.call('HSET', key, 'tokens', tokens, 'ts', string.format('%d', ts))
+redis.call('PEXPIRE', key, math.ceil(full / 1000) + 1)
+return {limited, tokens, retry, full}
@@ -0,0 +1,12 @@
+-- 把令牌还回去，最多还到装满
+local key = KEYS[1]
+local capacity = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+
+local tokens = tonumber(redis.call('HGET', key, 'tokens'))
+if tokens == nil then
+    -- 不存在就是满的
+    return 0
+end
+redis.call('HSET', key, 'tokens', math.min(capacity, tokens + cost))
+return 1
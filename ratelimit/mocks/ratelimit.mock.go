@@ -13,6 +13,7 @@ import (
 	context "context"
 	reflect "reflect"
 
+	ratelimit "github.com/DaHuangQwQ/gpkg/ratelimit"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -53,3 +54,162 @@ func (mr *MockLimiterMockRecorder) Limit(ctx, key any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Limit", reflect.TypeOf((*MockLimiter)(nil).Limit), ctx, key)
 }
+
+// MockAcquirer is a mock of Acquirer interface.
+type MockAcquirer struct {
+	ctrl     *gomock.Controller
+	recorder *MockAcquirerMockRecorder
+}
+
+// MockAcquirerMockRecorder is the mock recorder for MockAcquirer.
+type MockAcquirerMockRecorder struct {
+	mock *MockAcquirer
+}
+
+// NewMockAcquirer creates a new mock instance.
+func NewMockAcquirer(ctrl *gomock.Controller) *MockAcquirer {
+	mock := &MockAcquirer{ctrl: ctrl}
+	mock.recorder = &MockAcquirerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAcquirer) EXPECT() *MockAcquirerMockRecorder {
+	return m.recorder
+}
+
+// Acquire mocks base method.
+func (m *MockAcquirer) Acquire(ctx context.Context, key string) (ratelimit.DoneFunc, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Acquire", ctx, key)
+	ret0, _ := ret[0].(ratelimit.DoneFunc)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Acquire indicates an expected call of Acquire.
+func (mr *MockAcquirerMockRecorder) Acquire(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Acquire", reflect.TypeOf((*MockAcquirer)(nil).Acquire), ctx, key)
+}
+
+// MockResultLimiter is a mock of ResultLimiter interface.
+type MockResultLimiter struct {
+	ctrl     *gomock.Controller
+	recorder *MockResultLimiterMockRecorder
+}
+
+// MockResultLimiterMockRecorder is the mock recorder for MockResultLimiter.
+type MockResultLimiterMockRecorder struct {
+	mock *MockResultLimiter
+}
+
+// NewMockResultLimiter creates a new mock instance.
+func NewMockResultLimiter(ctrl *gomock.Controller) *MockResultLimiter {
+	mock := &MockResultLimiter{ctrl: ctrl}
+	mock.recorder = &MockResultLimiterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockResultLimiter) EXPECT() *MockResultLimiterMockRecorder {
+	return m.recorder
+}
+
+// Allow mocks base method.
+func (m *MockResultLimiter) Allow(ctx context.Context, key string) (ratelimit.Result, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Allow", ctx, key)
+	ret0, _ := ret[0].(ratelimit.Result)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Allow indicates an expected call of Allow.
+func (mr *MockResultLimiterMockRecorder) Allow(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Allow", reflect.TypeOf((*MockResultLimiter)(nil).Allow), ctx, key)
+}
+
+// AllowN mocks base method.
+func (m *MockResultLimiter) AllowN(ctx context.Context, key string, n int64) (ratelimit.Result, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AllowN", ctx, key, n)
+	ret0, _ := ret[0].(ratelimit.Result)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AllowN indicates an expected call of AllowN.
+func (mr *MockResultLimiterMockRecorder) AllowN(ctx, key, n any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllowN", reflect.TypeOf((*MockResultLimiter)(nil).AllowN), ctx, key, n)
+}
+
+// Limit mocks base method.
+func (m *MockResultLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Limit", ctx, key)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Limit indicates an expected call of Limit.
+func (mr *MockResultLimiterMockRecorder) Limit(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Limit", reflect.TypeOf((*MockResultLimiter)(nil).Limit), ctx, key)
+}
+
+// MockAcquireLimiter is a mock of AcquireLimiter interface.
+type MockAcquireLimiter struct {
+	ctrl     *gomock.Controller
+	recorder *MockAcquireLimiterMockRecorder
+}
+
+// MockAcquireLimiterMockRecorder is the mock recorder for MockAcquireLimiter.
+type MockAcquireLimiterMockRecorder struct {
+	mock *MockAcquireLimiter
+}
+
+// NewMockAcquireLimiter creates a new mock instance.
+func NewMockAcquireLimiter(ctrl *gomock.Controller) *MockAcquireLimiter {
+	mock := &MockAcquireLimiter{ctrl: ctrl}
+	mock.recorder = &MockAcquireLimiterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAcquireLimiter) EXPECT() *MockAcquireLimiterMockRecorder {
+	return m.recorder
+}
+
+// Acquire mocks base method.
+func (m *MockAcquireLimiter) Acquire(ctx context.Context, key string) (ratelimit.DoneFunc, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Acquire", ctx, key)
+	ret0, _ := ret[0].(ratelimit.DoneFunc)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Acquire indicates an expected call of Acquire.
+func (mr *MockAcquireLimiterMockRecorder) Acquire(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Acquire", reflect.TypeOf((*MockAcquireLimiter)(nil).Acquire), ctx, key)
+}
+
+// Limit mocks base method.
+func (m *MockAcquireLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Limit", ctx, key)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Limit indicates an expected call of Limit.
+func (mr *MockAcquireLimiterMockRecorder) Limit(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Limit", reflect.TypeOf((*MockAcquireLimiter)(nil).Limit), ctx, key)
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import "time"
+
+type Option func(o *options)
+
+type options struct {
+	// 分片数量, 减少锁竞争
+	shards int
+	// 空闲多久之后清理 key 的状态
+	idleTimeout time.Duration
+	// 统计窗口和窗口里面桶的数量
+	window  time.Duration
+	buckets int
+	// 在途请求少于这个数的时候不会触发自适应限流
+	minInflight int64
+	clock       Clock
+}
+
+func newOptions(opts []Option) options {
+	res := options{
+		shards:      32,
+		idleTimeout: time.Minute,
+		window:      time.Second * 10,
+		buckets:     100,
+		minInflight: 10,
+		clock:       systemClock{},
+	}
+	for _, opt := range opts {
+		opt(&res)
+	}
+	return res
+}
+
+// WithShards 按 key 维护状态的限流器的分片数量
+func WithShards(shards int) Option {
+	return func(o *options) {
+		if shards > 0 {
+			o.shards = shards
+		}
+	}
+}
+
+// WithIdleTimeout key 空闲超过 timeout 之后清理它的状态
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.idleTimeout = timeout
+		}
+	}
+}
+
+// WithWindow 自适应限流的统计窗口，分成 buckets 个桶
+func WithWindow(window time.Duration, buckets int) Option {
+	return func(o *options) {
+		if window > 0 && buckets > 0 {
+			o.window = window
+			o.buckets = buckets
+		}
+	}
+}
+
+// WithMinInflight 在途请求少于 n 的时候不会触发自适应限流，避免流量很小的时候误判
+func WithMinInflight(n int64) Option {
+	return func(o *options) {
+		o.minInflight = n
+	}
+}
+
+// WithClock 替换获取当前时间的方式，一般是测试的时候用
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		if clock != nil {
+			o.clock = clock
+		}
+	}
+}
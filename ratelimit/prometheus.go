@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// PrometheusDecorator 给限流器加上可观测性
+// 记录每次判断的结果 (allowed/rejected/error) 和耗时，summary 的 count 就是各个结果的次数
+type PrometheusDecorator struct {
+	vector *prometheus.SummaryVec
+	// key 本身是无限的，比如 ip，不能直接作为 label
+	keyClass func(key string) string
+}
+
+func NewPrometheusDecorator(opt prometheus.SummaryOpts) *PrometheusDecorator {
+	vector := prometheus.NewSummaryVec(opt, []string{"name", "key_class", "result"})
+	prometheus.MustRegister(vector)
+	return &PrometheusDecorator{
+		vector: vector,
+		keyClass: func(key string) string {
+			return "all"
+		},
+	}
+}
+
+// KeyClass 把 key 归类，返回值的数量必须是有限的，例如 ip:xxx 归类为 ip
+func (p *PrometheusDecorator) KeyClass(fn func(key string) string) *PrometheusDecorator {
+	p.keyClass = fn
+	return p
+}
+
+// Decorate name 用来区分不同的限流器
+// 返回的限流器保留 limiter 实现的 Acquirer 和 Reserver，Acquire 和 Reserve 也会记录
+func (p *PrometheusDecorator) Decorate(name string, limiter Limiter) Limiter {
+	res := &prometheusLimiter{
+		limiter:   limiter,
+		name:      name,
+		decorator: p,
+	}
+	_, acquire := limiter.(Acquirer)
+	_, reserve := limiter.(Reserver)
+	switch {
+	case acquire && reserve:
+		return &prometheusAcquireReserveLimiter{prometheusLimiter: res}
+	case acquire:
+		return &prometheusAcquireLimiter{prometheusLimiter: res}
+	case reserve:
+		return &prometheusReserveLimiter{prometheusLimiter: res}
+	default:
+		return res
+	}
+}
+
+type prometheusLimiter struct {
+	limiter   Limiter
+	name      string
+	decorator *PrometheusDecorator
+}
+
+func (p *prometheusLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(p.Allow(ctx, key))
+}
+
+func (p *prometheusLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return p.AllowN(ctx, key, 1)
+}
+
+func (p *prometheusLimiter) AllowN(ctx context.Context, key string, n int64) (res Result, err error) {
+	start := time.Now()
+	defer func() {
+		p.observe(key, start, res.Allowed, err)
+	}()
+	res, err = AllowN(ctx, p.limiter, key, n)
+	return
+}
+
+func (p *prometheusLimiter) Refund(ctx context.Context, key string, n int64) error {
+	if r, ok := p.limiter.(Refunder); ok {
+		return r.Refund(ctx, key, n)
+	}
+	return nil
+}
+
+// acquire 被限流的时候 Acquire 返回 ErrLimitExceeded，不算 error
+func (p *prometheusLimiter) acquire(ctx context.Context, key string) (DoneFunc, error) {
+	start := time.Now()
+	done, err := p.limiter.(Acquirer).Acquire(ctx, key)
+	if errors.Is(err, ErrLimitExceeded) {
+		p.observe(key, start, false, nil)
+	} else {
+		p.observe(key, start, err == nil, err)
+	}
+	return done, err
+}
+
+// reserve 预留不到配额的时候算 rejected
+func (p *prometheusLimiter) reserve(ctx context.Context, key string, n int64) (*Reservation, error) {
+	start := time.Now()
+	rv, err := p.limiter.(Reserver).Reserve(ctx, key, n)
+	p.observe(key, start, err == nil && rv.OK(), err)
+	return rv, err
+}
+
+func (p *prometheusLimiter) observe(key string, start time.Time, allowed bool, err error) {
+	result := "allowed"
+	if err != nil {
+		result = "error"
+	} else if !allowed {
+		result = "rejected"
+	}
+	// 限流判断一般是微秒级别的，这里保留小数
+	duration := float64(time.Since(start)) / float64(time.Millisecond)
+	p.decorator.vector.WithLabelValues(p.name, p.decorator.keyClass(key), result).
+		Observe(duration)
+}
+
+// prometheusAcquireLimiter 被装饰的是并发限流、自适应限流这种 AcquireLimiter
+type prometheusAcquireLimiter struct {
+	*prometheusLimiter
+}
+
+func (p *prometheusAcquireLimiter) Acquire(ctx context.Context, key string) (DoneFunc, error) {
+	return p.acquire(ctx, key)
+}
+
+// prometheusReserveLimiter 被装饰的限流器支持预留，例如 GCRA 和令牌桶
+type prometheusReserveLimiter struct {
+	*prometheusLimiter
+}
+
+func (p *prometheusReserveLimiter) Reserve(ctx context.Context, key string, n int64) (*Reservation, error) {
+	return p.reserve(ctx, key, n)
+}
+
+type prometheusAcquireReserveLimiter struct {
+	*prometheusLimiter
+}
+
+func (p *prometheusAcquireReserveLimiter) Acquire(ctx context.Context, key string) (DoneFunc, error) {
+	return p.acquire(ctx, key)
+}
+
+func (p *prometheusAcquireReserveLimiter) Reserve(ctx context.Context, key string, n int64) (*Reservation, error) {
+	return p.reserve(ctx, key, n)
+}
@@ -3,8 +3,16 @@ package ratelimit
 import (
 	"context"
 	"errors"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/DaHuangQwQ/gpkg/logger/loggertest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -28,7 +36,7 @@ func ratelimit(t *testing.T, limiter Limiter) {
 }
 
 func TestTokenBucketLimiter(t *testing.T) {
-	limiter := NewTokenBucketLimiter(time.Second, 1000)
+	limiter := NewTokenBucketLimiter(time.Second, 10)
 	ratelimit(t, limiter)
 }
 
@@ -41,3 +49,528 @@ func TestSlidingWindowLimiter(t *testing.T) {
 	limiter := NewSlidingWindowLimiter(time.Second, 10)
 	ratelimit(t, limiter)
 }
+
+func TestGCRALimiter(t *testing.T) {
+	limiter := NewGCRALimiter(time.Second, 10, 10)
+	ratelimit(t, limiter)
+}
+
+func TestLimiterParams(t *testing.T) {
+	testCases := []struct {
+		name    string
+		newFunc func()
+		wantMsg string
+	}{
+		{
+			name:    "GCRA rate 为 0",
+			newFunc: func() { NewGCRALimiter(time.Second, 0, 1) },
+			wantMsg: "ratelimit: rate 必须大于 0，实际是 0",
+		},
+		{
+			name:    "GCRA burst 为 0",
+			newFunc: func() { NewGCRALimiter(time.Second, 1, 0) },
+			wantMsg: "ratelimit: burst 必须大于 0，实际是 0",
+		},
+		{
+			name:    "redis GCRA 间隔不足 1µs",
+			newFunc: func() { NewRedisGCRALimiter(nil, time.Second, 2_000_000, 1) },
+			wantMsg: "ratelimit: interval / rate 不能小于 1µs，实际是 500ns",
+		},
+		{
+			name:    "令牌桶 interval 为 0",
+			newFunc: func() { NewTokenBucketLimiter(0, 1) },
+			wantMsg: "ratelimit: interval 不能小于 1µs，实际是 0s",
+		},
+		{
+			name:    "redis 令牌桶 capacity 为 0",
+			newFunc: func() { NewRedisTokenBucketLimiter(nil, time.Second, 0) },
+			wantMsg: "ratelimit: capacity 必须大于 0，实际是 0",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.PanicsWithValue(t, tc.wantMsg, tc.newFunc)
+		})
+	}
+}
+
+func TestGCRALimiterKey(t *testing.T) {
+	limiter := NewGCRALimiter(time.Second, 1, 1)
+	limited, err := limiter.Limit(context.Background(), "a")
+	require.NoError(t, err)
+	require.False(t, limited)
+	limited, err = limiter.Limit(context.Background(), "a")
+	require.Equal(t, ErrLimitExceeded, err)
+	require.True(t, limited)
+	// 不同的 key 互不影响
+	limited, err = limiter.Limit(context.Background(), "b")
+	require.NoError(t, err)
+	require.False(t, limited)
+}
+
+func TestWait(t *testing.T) {
+	testCases := []struct {
+		name    string
+		limiter Limiter
+	}{
+		{
+			name:    "reserve",
+			limiter: NewGCRALimiter(time.Second, 50, 1),
+		},
+		{
+			name:    "retry",
+			limiter: NewSlidingWindowLimiter(time.Millisecond*20, 1),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				require.NoError(t, Wait(ctx, tc.limiter, "test"))
+			}
+			require.GreaterOrEqual(t, time.Since(start), time.Millisecond*35)
+		})
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	limiter := NewGCRALimiter(time.Second, 1, 1)
+	require.NoError(t, Wait(context.Background(), limiter, "test"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	require.ErrorIs(t, Wait(ctx, limiter, "test"), context.DeadlineExceeded)
+	// 超时之后预留的配额还回去了
+	rv, err := limiter.(Reserver).Reserve(context.Background(), "test", 1)
+	require.NoError(t, err)
+	require.InDelta(t, time.Second, rv.Delay(), float64(time.Millisecond*50))
+}
+
+func TestAllowN(t *testing.T) {
+	testCases := []struct {
+		name    string
+		limiter Limiter
+	}{
+		{
+			name:    "fix window",
+			limiter: NewFixWindowLimiter(time.Second, 10),
+		},
+		{
+			name:    "sliding window",
+			limiter: NewSlidingWindowLimiter(time.Second, 10),
+		},
+		{
+			name:    "gcra",
+			limiter: NewGCRALimiter(time.Second, 10, 10),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := AllowN(context.Background(), tc.limiter, "test", 8)
+			require.NoError(t, err)
+			require.True(t, res.Allowed)
+			require.Equal(t, int64(2), res.Remaining)
+			// 剩下的不够 3 个，一个都不会拿
+			res, err = AllowN(context.Background(), tc.limiter, "test", 3)
+			require.NoError(t, err)
+			require.False(t, res.Allowed)
+			require.Equal(t, int64(2), res.Remaining)
+			require.Greater(t, res.RetryAfter, time.Duration(0))
+			res, err = AllowN(context.Background(), tc.limiter, "test", 2)
+			require.NoError(t, err)
+			require.True(t, res.Allowed)
+			require.Equal(t, int64(0), res.Remaining)
+			// 负数不能用来凭空产生配额
+			_, err = tc.limiter.(ResultLimiter).AllowN(context.Background(), "test", -2)
+			require.ErrorIs(t, err, ErrInvalidPermits)
+			_, err = AllowN(context.Background(), tc.limiter, "test", 0)
+			require.ErrorIs(t, err, ErrInvalidPermits)
+			res, err = AllowN(context.Background(), tc.limiter, "test", 1)
+			require.NoError(t, err)
+			require.False(t, res.Allowed)
+		})
+	}
+}
+
+func TestAllowN_PlainLimiter(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(1)
+	require.NoError(t, err)
+	res, err := AllowN(context.Background(), limiter, "test", 1)
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+	// 不支持权重的限流器，不能悄悄地只扣一个配额
+	_, err = AllowN(context.Background(), limiter, "test", 2)
+	require.ErrorIs(t, err, ErrPermitsNotSupported)
+}
+
+func TestCompositeLimiter(t *testing.T) {
+	user := NewGCRALimiter(time.Second, 2, 2)
+	tenant := NewFixWindowLimiter(time.Second, 3)
+	limiter := NewCompositeLimiter(
+		Stage{Limiter: user},
+		Stage{Limiter: tenant, Key: StaticKey("tenant")},
+	)
+	ctx := context.Background()
+	for _, key := range []string{"a", "a", "b"} {
+		limited, err := limiter.Limit(ctx, key)
+		require.NoError(t, err)
+		require.False(t, limited)
+	}
+	// 用户这一层没超，租户这一层超了，用户这一层的配额要还回去
+	limited, err := limiter.Limit(ctx, "b")
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	require.True(t, limited)
+	res, err := Allow(ctx, user, "b")
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+	// 用户这一层超了，就不会再判断租户
+	limited, err = limiter.Limit(ctx, "a")
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	require.True(t, limited)
+}
+
+func TestBBRLimiter(t *testing.T) {
+	limiter := NewBBRLimiter(WithWindow(time.Millisecond*100, 10), WithMinInflight(1))
+	ctx := context.Background()
+	// 串行的请求，系统能承受的在途请求数大概是 1
+	start := time.Now()
+	for time.Since(start) < time.Millisecond*50 {
+		done, err := limiter.Acquire(ctx, "test")
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		done()
+	}
+	// 请求开始堆积
+	var dones []DoneFunc
+	var err error
+	for i := 0; i < 5 && err == nil; i++ {
+		var done DoneFunc
+		done, err = limiter.Acquire(ctx, "test")
+		if done != nil {
+			dones = append(dones, done)
+		}
+	}
+	require.Equal(t, ErrLimitExceeded, err)
+	for _, done := range dones {
+		done()
+	}
+}
+
+type errLimiter struct {
+	err   error
+	calls int
+}
+
+func (e *errLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	e.calls++
+	return e.err != nil, e.err
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	redisErr := errors.New("redis 崩了")
+	remote := &errLimiter{}
+	clock := &fakeClock{now: time.UnixMilli(1700000000000)}
+	limiter := NewFallbackLimiter(remote, func(replicas int) Limiter {
+		return NewFixWindowLimiter(time.Minute, int64(30/replicas), WithClock(clock))
+	}, func() int {
+		return 3
+	}).ErrorThreshold(2).RecoverThreshold(2).ProbeInterval(time.Second).Clock(clock)
+	ctx := context.Background()
+	limit := func(err error) {
+		remote.err = err
+		limited, er := limiter.Limit(ctx, "test")
+		require.NoError(t, er)
+		require.False(t, limited)
+	}
+
+	// 偶尔失败不会降级，失败的请求由本地限流器判断
+	for i := 0; i < 3; i++ {
+		limit(redisErr)
+		limit(nil)
+	}
+	require.False(t, limiter.Fallback())
+
+	// 连续失败才降级
+	limit(redisErr)
+	limit(redisErr)
+	require.True(t, limiter.Fallback())
+	calls := remote.calls
+	limit(nil)
+	require.Equal(t, calls, remote.calls)
+
+	// 试探成功一次还不够
+	clock.now = clock.now.Add(time.Second)
+	limit(nil)
+	require.Equal(t, calls+1, remote.calls)
+	require.True(t, limiter.Fallback())
+	limit(nil)
+	require.Equal(t, calls+1, remote.calls)
+
+	// 试探失败，重新计数
+	clock.now = clock.now.Add(time.Second)
+	limit(redisErr)
+	require.True(t, limiter.Fallback())
+	clock.now = clock.now.Add(time.Second)
+	limit(nil)
+	require.True(t, limiter.Fallback())
+	clock.now = clock.now.Add(time.Second)
+	limit(nil)
+	require.False(t, limiter.Fallback())
+	require.Equal(t, calls+4, remote.calls)
+
+	// 恢复之后，远程限流器的结果才算数
+	remote.err = ErrLimitExceeded
+	limited, err := limiter.Limit(ctx, "test")
+	require.True(t, limited)
+	require.ErrorIs(t, err, ErrLimitExceeded)
+}
+
+func TestRuleLimiter(t *testing.T) {
+	limiter := NewRuleLimiter(nil, logger.NewNoOpLogger())
+	rules, err := ParseRules([]byte(`[
+	{"name": "export", "method": "/user.UserService/Export", "algorithm": "gcra", "rate": 1, "window": "1s"},
+	{"name": "ip", "key_prefix": "ip:", "algorithm": "fix_window", "rate": 2, "window": "1m"}
+]`))
+	require.NoError(t, err)
+	require.NoError(t, limiter.Update(rules))
+
+	ctx := context.Background()
+	exportCtx := ContextWithMethod(ctx, "/user.UserService/Export")
+	limited, err := limiter.Limit(exportCtx, "ip:127.0.0.1")
+	require.NoError(t, err)
+	require.False(t, limited)
+	limited, err = limiter.Limit(exportCtx, "ip:127.0.0.1")
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	require.True(t, limited)
+
+	// 没有匹配上任何规则
+	limited, err = limiter.Limit(ctx, "user:1")
+	require.NoError(t, err)
+	require.False(t, limited)
+
+	// 非法的规则不会生效
+	err = limiter.Update([]Rule{{Name: "ip", Algorithm: "unknown", Rate: 1, Window: Duration(time.Second)}})
+	require.Error(t, err)
+	require.Len(t, limiter.Rules(), 2)
+	// 每个请求的间隔不足 1µs
+	err = limiter.Update([]Rule{{Name: "ip", Algorithm: AlgorithmGCRA, Rate: 2_000_000, Window: Duration(time.Second)}})
+	require.EqualError(t, err, "规则 ip window / rate 不能小于 1µs")
+	require.Len(t, limiter.Rules(), 2)
+
+	// 只调整阈值，算法不变，已经消耗的配额还在
+	rules[0].Rate = 2
+	require.NoError(t, limiter.Update(rules))
+	limited, err = limiter.Limit(exportCtx, "ip:127.0.0.1")
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	require.True(t, limited)
+}
+
+func TestRuleLimiter_Keyed(t *testing.T) {
+	ctx := context.Background()
+	for _, algorithm := range []string{AlgorithmFixWindow, AlgorithmSlidingWindow, AlgorithmTokenBucket, AlgorithmGCRA} {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter := NewRuleLimiter(nil, logger.NewNoOpLogger())
+			rules := []Rule{{Name: "ip", KeyPrefix: "ip:", Algorithm: algorithm, Rate: 1, Window: Duration(time.Minute)}}
+			require.NoError(t, limiter.Update(rules))
+			// 新的规则一开始就有配额，每个 key 分别计数
+			for _, key := range []string{"ip:1", "ip:2"} {
+				limited, err := limiter.Limit(ctx, key)
+				require.NoError(t, err)
+				require.False(t, limited)
+				limited, err = limiter.Limit(ctx, key)
+				require.ErrorIs(t, err, ErrLimitExceeded)
+				require.True(t, limited)
+			}
+
+			// 调整阈值的时候，先创建好新的限流器再整体替换，旧的限流器不受影响
+			old := (*limiter.entries.Load())[0].limiter
+			rules[0].Rate = 3
+			require.NoError(t, limiter.Update(rules))
+			cur := (*limiter.entries.Load())[0].limiter
+			require.NotSame(t, old, cur)
+			if k, ok := old.(*keyedLimiter); ok {
+				require.Equal(t, int64(1), k.rule.Rate)
+			} else {
+				require.Equal(t, int64(1), old.(*gcraLimiter).params.Load().burst)
+			}
+			// 已经消耗的配额还在，比新的 key 剩下的少
+			res, err := limiter.Allow(ctx, "ip:1")
+			require.NoError(t, err)
+			require.Equal(t, int64(3), res.Limit)
+			fresh, err := limiter.Allow(ctx, "ip:3")
+			require.NoError(t, err)
+			require.True(t, fresh.Allowed)
+			require.Equal(t, int64(3), fresh.Limit)
+			require.Less(t, res.Remaining, fresh.Remaining)
+		})
+	}
+}
+
+func TestRuleLimiter_KeyedSweep(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1700000000000)}
+	limiter := NewRuleLimiter(nil, logger.NewNoOpLogger(), WithShards(1), WithClock(clock))
+	require.NoError(t, limiter.Update([]Rule{{Name: "ip", Algorithm: AlgorithmFixWindow, Rate: 1, Window: Duration(time.Hour)}}))
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		_, err := limiter.Allow(ctx, strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+	keyed := (*limiter.entries.Load())[0].limiter.(*keyedLimiter)
+	require.Len(t, keyed.shards[0].items, 10)
+
+	// 窗口还没有过去，不能清理，不然就多放行了
+	clock.now = clock.now.Add(time.Minute * 30)
+	res, err := limiter.Allow(ctx, "0")
+	require.NoError(t, err)
+	require.False(t, res.Allowed)
+	require.Len(t, keyed.shards[0].items, 10)
+
+	// 其余的 key 空闲超过一个窗口了，0 在 30 分钟的时候还用过
+	clock.now = clock.now.Add(time.Hour)
+	_, err = limiter.Allow(ctx, "new")
+	require.NoError(t, err)
+	require.Len(t, keyed.shards[0].items, 2)
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`[{"name": "ip", "algorithm": "gcra", "rate": 10, "window": "1m"}]`))
+	require.NoError(t, err)
+	require.Equal(t, Duration(time.Minute), rules[0].Window)
+
+	// 数字没有单位，不知道是秒还是纳秒
+	_, err = ParseRules([]byte(`[{"name": "ip", "algorithm": "gcra", "rate": 10, "window": 1}]`))
+	require.EqualError(t, err, `非法的时间 1，需要写成 "1s" 这种形式`)
+}
+
+func TestFileRuleSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path,
+		[]byte(`[{"name": "all", "algorithm": "fix_window", "rate": 1, "window": "1m"}]`), 0644))
+	limiter := NewRuleLimiter(nil, logger.NewNoOpLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, limiter.Watch(ctx, NewFileRuleSource(path, time.Millisecond*10)))
+	require.Equal(t, int64(1), limiter.Rules()[0].Rate)
+
+	require.NoError(t, os.WriteFile(path,
+		[]byte(`[{"name": "all", "algorithm": "fix_window", "rate": 10, "window": "1m"}]`), 0644))
+	require.Eventually(t, func() bool {
+		return limiter.Rules()[0].Rate == 10
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestPrometheusDecorator(t *testing.T) {
+	decorator := NewPrometheusDecorator(prometheus.SummaryOpts{
+		Namespace: "gpkg",
+		Subsystem: "ratelimit",
+		Name:      "test",
+	}).KeyClass(func(key string) string {
+		return strings.SplitN(key, ":", 2)[0]
+	})
+	limiter := decorator.Decorate("fix_window", NewFixWindowLimiter(time.Minute, 1))
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, _ = limiter.Limit(ctx, "ip:127.0.0.1")
+	}
+	_, _ = decorator.Decorate("err", &errLimiter{err: errors.New("mock error")}).Limit(ctx, "ip:127.0.0.1")
+
+	// allowed rejected error 各一个
+	require.Equal(t, 3, testutil.CollectAndCount(decorator.vector, "gpkg_ratelimit_test"))
+	_, ok := limiter.(Acquirer)
+	require.False(t, ok)
+	_, ok = limiter.(Reserver)
+	require.False(t, ok)
+
+	// 保留 Acquire，不然装饰之后就永远不会限流了
+	concurrency, err := NewConcurrencyLimiter(1)
+	require.NoError(t, err)
+	acquirer, ok := decorator.Decorate("concurrency", concurrency).(AcquireLimiter)
+	require.True(t, ok)
+	done, err := acquirer.Acquire(ctx, "ip:127.0.0.1")
+	require.NoError(t, err)
+	_, err = acquirer.Acquire(ctx, "ip:127.0.0.1")
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	done()
+	// 多了 concurrency 的 allowed 和 rejected
+	require.Equal(t, 5, testutil.CollectAndCount(decorator.vector, "gpkg_ratelimit_test"))
+
+	// 保留 Reserve
+	reserver, ok := decorator.Decorate("gcra", NewGCRALimiter(time.Second, 1, 1)).(Reserver)
+	require.True(t, ok)
+	rv, err := reserver.Reserve(ctx, "ip:127.0.0.1", 1)
+	require.NoError(t, err)
+	require.True(t, rv.OK())
+	require.Equal(t, time.Duration(0), rv.Delay())
+	rv, err = reserver.Reserve(ctx, "ip:127.0.0.1", 2)
+	require.NoError(t, err)
+	require.False(t, rv.OK())
+	require.Equal(t, 7, testutil.CollectAndCount(decorator.vector, "gpkg_ratelimit_test"))
+}
+
+func TestShadowLimiter(t *testing.T) {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gpkg",
+		Subsystem: "ratelimit",
+		Name:      "shadow_test",
+	}, []string{"name"})
+	limiter := NewShadowLimiter("fix_window", NewFixWindowLimiter(time.Minute, 1), logger.NewNoOpLogger()).
+		Metrics(counter)
+	for i := 0; i < 3; i++ {
+		limited, err := limiter.Limit(context.Background(), "test")
+		require.NoError(t, err)
+		require.False(t, limited)
+	}
+	require.Equal(t, float64(2), testutil.ToFloat64(counter.WithLabelValues("fix_window")))
+
+	// 日志是采样的，counter 依旧是准确的
+	l := loggertest.NewLogger()
+	limiter = NewShadowLimiter("fix_window", NewFixWindowLimiter(time.Minute, 1), l).
+		Metrics(counter).
+		Sample(time.Minute, 2, 5)
+	for i := 0; i < 11; i++ {
+		limited, err := limiter.Limit(context.Background(), "sample")
+		require.NoError(t, err)
+		require.False(t, limited)
+	}
+	// 第 1 个请求没有被限流，之后的 10 次里面输出第 1、2、7 次
+	require.Len(t, l.FilterMessage("影子限流触发限流，已放行"), 3)
+	require.Equal(t, float64(12), testutil.ToFloat64(counter.WithLabelValues("fix_window")))
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	_, err := NewConcurrencyLimiter(0)
+	require.EqualError(t, err, "ratelimit: capacity 必须大于 0，实际是 0")
+
+	limiter, err := NewConcurrencyLimiter(2)
+	require.NoError(t, err)
+	ctx := context.Background()
+	done1, err := limiter.Acquire(ctx, "test")
+	require.NoError(t, err)
+	done2, err := limiter.Acquire(ctx, "test")
+	require.NoError(t, err)
+	_, err = limiter.Acquire(ctx, "test")
+	require.Equal(t, ErrLimitExceeded, err)
+	// 不同的 key 互不影响
+	done3, err := limiter.Acquire(ctx, "other")
+	require.NoError(t, err)
+	done3()
+
+	done1()
+	// 重复释放只算一次
+	done1()
+	done4, err := limiter.Acquire(ctx, "test")
+	require.NoError(t, err)
+	_, err = limiter.Acquire(ctx, "test")
+	require.Equal(t, ErrLimitExceeded, err)
+	done2()
+	done4()
+}
@@ -0,0 +1,61 @@
+package ratelimittest
+
+import (
+	"sync"
+	"time"
+)
+
+// ManualClock 手动推进的时钟，实现了 ratelimit.Clock
+type ManualClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers map[*manualTicker]struct{}
+}
+
+type manualTicker struct {
+	ch     chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now, tickers: make(map[*manualTicker]struct{})}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// NewTicker 时钟推进的时候触发，和 time.Ticker 一样，来不及消费的 tick 会被丢掉
+func (c *ManualClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &manualTicker{ch: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers[t] = struct{}{}
+	return t.ch, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		delete(c.tickers, t)
+	}
+}
+
+// Add 时钟往前推进 d
+func (c *ManualClock) Add(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	for t := range c.tickers {
+		if t.next.After(c.now) {
+			continue
+		}
+		select {
+		case t.ch <- c.now:
+		default:
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package ratelimittest
+
+import (
+	"context"
+	"fmt"
+	"github.com/DaHuangQwQ/gpkg/ratelimit"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Arrival 请求到达的轨迹里面的一个请求
+type Arrival struct {
+	// 相对模拟开始的时间，必须是递增的
+	At  time.Duration
+	Key string
+	// 消耗的配额，为 0 就是 1
+	N int64
+	// 期望是否放行
+	Allowed bool
+}
+
+// Simulation 按照到达轨迹推进时钟，逐个判断限流，对比每一个请求的结果
+type Simulation struct {
+	Clock *ManualClock
+	// 时钟推进的时候回调，例如让 miniredis 里面的 key 过期
+	OnAdvance func(d time.Duration)
+
+	elapsed time.Duration
+}
+
+func NewSimulation(clock *ManualClock) *Simulation {
+	return &Simulation{Clock: clock}
+}
+
+// Replay 返回每个请求实际是否放行
+func (s *Simulation) Replay(ctx context.Context, limiter ratelimit.Limiter, trace []Arrival) ([]bool, error) {
+	res := make([]bool, 0, len(trace))
+	for i, arrival := range trace {
+		if arrival.At < s.elapsed {
+			return res, fmt.Errorf("第 %d 个请求的到达时间 %s 早于上一个请求", i, arrival.At)
+		}
+		s.advance(arrival.At - s.elapsed)
+		n := arrival.N
+		if n == 0 {
+			n = 1
+		}
+		r, err := ratelimit.AllowN(ctx, limiter, arrival.Key, n)
+		if err != nil {
+			return res, fmt.Errorf("第 %d 个请求: %w", i, err)
+		}
+		res = append(res, r.Allowed)
+	}
+	return res, nil
+}
+
+// Run 回放并且断言每一个请求的结果都和期望一致
+func (s *Simulation) Run(t *testing.T, limiter ratelimit.Limiter, trace []Arrival) {
+	t.Helper()
+	actual, err := s.Replay(context.Background(), limiter, trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]bool, 0, len(trace))
+	for _, arrival := range trace {
+		expected = append(expected, arrival.Allowed)
+	}
+	if want, got := Format(expected), Format(actual); want != got {
+		t.Errorf("放行序列不一致\n期望: %s\n实际: %s", want, got)
+	}
+}
+
+func (s *Simulation) advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	s.elapsed += d
+	s.Clock.Add(d)
+	if s.OnAdvance != nil {
+		s.OnAdvance(d)
+	}
+}
+
+// Format 放行是 +，限流是 -，方便对比
+func Format(allowed []bool) string {
+	var sb strings.Builder
+	for _, a := range allowed {
+		if a {
+			sb.WriteByte('+')
+		} else {
+			sb.WriteByte('-')
+		}
+	}
+	return sb.String()
+}
+
+// Every 从 start 开始每隔 interval 到达一个请求，期望的结果按照 pattern，+ 是放行，- 是限流
+func Every(start time.Duration, interval time.Duration, key string, pattern string) []Arrival {
+	res := make([]Arrival, 0, len(pattern))
+	for i, c := range pattern {
+		res = append(res, Arrival{
+			At:      start + interval*time.Duration(i),
+			Key:     key,
+			Allowed: c == '+',
+		})
+	}
+	return res
+}
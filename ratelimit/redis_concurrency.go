@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//go:embed lua/semaphore.lua
+var luaSemaphoreScript string
+
+// redisConcurrencyLimiter 分布式的并发限流
+// 每个持有者都有租约，持有期间会自动续约，进程崩溃之后租约过期，信号量就会被释放
+type redisConcurrencyLimiter struct {
+	client   redis.Cmdable
+	capacity int
+	lease    time.Duration
+	clock    Clock
+}
+
+// NewRedisConcurrencyLimiter 每个 key 最多 capacity 个在途请求，lease 是租约时长
+// redis 上按照毫秒计算租约，所以 lease 不能小于 1ms
+func NewRedisConcurrencyLimiter(client redis.Cmdable, capacity int, lease time.Duration, opts ...Option) (AcquireLimiter, error) {
+	if capacity < 1 {
+		return nil, fmt.Errorf("ratelimit: capacity 必须大于 0，实际是 %d", capacity)
+	}
+	if lease < time.Millisecond {
+		return nil, fmt.Errorf("ratelimit: lease 不能小于 1ms，实际是 %s", lease)
+	}
+	o := newOptions(opts)
+	return &redisConcurrencyLimiter{
+		client:   client,
+		capacity: capacity,
+		lease:    lease,
+		clock:    o.clock,
+	}, nil
+}
+
+// Limit 只判断现在有没有空闲的信号量，不会占用
+// 注意：只调用 Limit 的话信号量永远不会被占用，也就永远不会限流，必须通过 Acquire 使用
+func (r *redisConcurrencyLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	cnt, err := r.client.ZCount(ctx, key, strconv.FormatInt(r.clock.Now().UnixMilli(), 10), "+inf").Result()
+	if err != nil {
+		return true, err
+	}
+	if cnt >= int64(r.capacity) {
+		return true, ErrLimitExceeded
+	}
+	return false, nil
+}
+
+func (r *redisConcurrencyLimiter) Acquire(ctx context.Context, key string) (DoneFunc, error) {
+	id := uuid.NewString()
+	ok, err := r.client.Eval(ctx, luaSemaphoreScript, []string{key},
+		r.capacity, r.lease.Milliseconds(), r.clock.Now().UnixMilli(), id).Bool()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLimitExceeded
+	}
+	// 在返回之前创建 ticker，保证时钟推进的时候一定会续约
+	ticks, stopTicker := newTicker(r.clock, r.lease/3)
+	stop := make(chan struct{})
+	go r.keepAlive(key, id, ticks, stop)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			stopTicker()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			// 释放失败也没关系，租约过期之后会被清理
+			_ = r.client.ZRem(ctx, key, id).Err()
+		})
+	}, nil
+}
+
+// keepAlive 每过三分之一的租约时长续约一次，ticker 和租约的过期时间都来自 r.clock
+func (r *redisConcurrencyLimiter) keepAlive(key string, id string, ticks <-chan time.Time, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticks:
+			ctx, cancel := context.WithTimeout(context.Background(), r.lease/3)
+			expireAt := r.clock.Now().Add(r.lease).UnixMilli()
+			// 只续约自己，已经被清理掉的就不再加回去
+			_ = r.client.ZAddXX(ctx, key, redis.Z{Score: float64(expireAt), Member: id}).Err()
+			_ = r.client.PExpire(ctx, key, r.lease).Err()
+			cancel()
+		}
+	}
+}
@@ -14,18 +14,48 @@ type redisFixWindowLimiter struct {
 	client   redis.Cmdable
 	interval time.Duration
 	// 阈值
-	rate int
+	rate  int
+	clock Clock
 }
 
-func newRedisFixWindowLimiter(client redis.Cmdable, interval time.Duration, rate int) Limiter {
+func NewRedisFixWindowLimiter(client redis.Cmdable, interval time.Duration, rate int, opts ...Option) Limiter {
+	o := newOptions(opts)
 	return &redisFixWindowLimiter{
 		client:   client,
 		interval: interval,
 		rate:     rate,
+		clock:    o.clock,
 	}
 }
 
 func (r *redisFixWindowLimiter) Limit(ctx context.Context, key string) (bool, error) {
-	return r.client.Eval(ctx, luaFixScript, []string{key},
-		r.interval.Milliseconds(), r.rate).Bool()
+	return limitOf(r.Allow(ctx, key))
+}
+
+func (r *redisFixWindowLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+func (r *redisFixWindowLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := checkPermits(n); err != nil {
+		return Result{}, err
+	}
+	now := r.clock.Now()
+	vals, err := r.client.Eval(ctx, luaFixScript, []string{key},
+		r.interval.Milliseconds(), r.rate, n).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	// {是否限流, 已经用掉的配额, 窗口剩余的毫秒数}
+	ttl := time.Duration(vals[2]) * time.Millisecond
+	res := Result{
+		Allowed:   vals[0] == 0,
+		Limit:     int64(r.rate),
+		Remaining: max(int64(r.rate)-vals[1], 0),
+		ResetAt:   now.Add(ttl),
+	}
+	if !res.Allowed {
+		res.RetryAfter = ttl
+	}
+	return res, nil
 }
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+//go:embed lua/gcra.lua
+var luaGCRAScript string
+
+//go:embed lua/gcra_refund.lua
+var luaGCRARefundScript string
+
+type redisGCRALimiter struct {
+	client redis.Cmdable
+	// 每个请求占用的时间
+	emission time.Duration
+	// 允许的突发
+	tolerance time.Duration
+	burst     int
+	clock     Clock
+}
+
+// NewRedisGCRALimiter 和 NewGCRALimiter 一样的算法，状态放在 redis 上，参数的限制也一样
+func NewRedisGCRALimiter(client redis.Cmdable, interval time.Duration, rate int, burst int, opts ...Option) Limiter {
+	o := newOptions(opts)
+	p := newGCRAParams(interval, int64(rate), int64(burst))
+	return &redisGCRALimiter{
+		client:    client,
+		emission:  p.emission,
+		tolerance: p.tolerance,
+		burst:     burst,
+		clock:     o.clock,
+	}
+}
+
+func (r *redisGCRALimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(r.Allow(ctx, key))
+}
+
+func (r *redisGCRALimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+func (r *redisGCRALimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := checkPermits(n); err != nil {
+		return Result{}, err
+	}
+	now := r.clock.Now()
+	vals, err := r.client.Eval(ctx, luaGCRAScript, []string{key},
+		r.emission.Microseconds(), r.tolerance.Microseconds(), now.UnixMicro(), n).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	// {是否限流, 剩余的突发配额, 多少微秒之后可以重试, 多少微秒之后完全恢复}
+	return Result{
+		Allowed:    vals[0] == 0,
+		Limit:      int64(r.burst),
+		Remaining:  vals[1],
+		ResetAt:    now.Add(time.Duration(vals[3]) * time.Microsecond),
+		RetryAfter: time.Duration(vals[2]) * time.Microsecond,
+	}, nil
+}
+
+func (r *redisGCRALimiter) Refund(ctx context.Context, key string, n int64) error {
+	if err := checkPermits(n); err != nil {
+		return err
+	}
+	return r.client.Eval(ctx, luaGCRARefundScript, []string{key},
+		(r.emission * time.Duration(n)).Microseconds(), r.clock.Now().UnixMicro()).Err()
+}
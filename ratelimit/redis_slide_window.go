@@ -3,6 +3,7 @@ package ratelimit
 import (
 	"context"
 	_ "embed"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"time"
 )
@@ -14,18 +15,44 @@ type redisSlidingWindowLimiter struct {
 	client   redis.Cmdable
 	interval time.Duration
 	// 阈值
-	rate int
+	rate  int
+	clock Clock
 }
 
-func NewRedisSlidingWindowLimiter(client redis.Cmdable, interval time.Duration, rate int) Limiter {
+func NewRedisSlidingWindowLimiter(client redis.Cmdable, interval time.Duration, rate int, opts ...Option) Limiter {
+	o := newOptions(opts)
 	return &redisSlidingWindowLimiter{
 		client:   client,
 		interval: interval,
 		rate:     rate,
+		clock:    o.clock,
 	}
 }
 
 func (b *redisSlidingWindowLimiter) Limit(ctx context.Context, key string) (bool, error) {
-	return b.client.Eval(ctx, luaSlideScript, []string{key},
-		b.interval.Milliseconds(), b.rate, time.Now().UnixMilli()).Bool()
+	return limitOf(b.Allow(ctx, key))
+}
+
+func (b *redisSlidingWindowLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return b.AllowN(ctx, key, 1)
+}
+
+func (b *redisSlidingWindowLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := checkPermits(n); err != nil {
+		return Result{}, err
+	}
+	now := b.clock.Now()
+	vals, err := b.client.Eval(ctx, luaSlideScript, []string{key},
+		b.interval.Milliseconds(), b.rate, now.UnixMilli(), n, uuid.NewString()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	// {是否限流, 窗口内的请求数, 多少毫秒之后可以重试, 多少毫秒之后窗口清空}
+	return Result{
+		Allowed:    vals[0] == 0,
+		Limit:      int64(b.rate),
+		Remaining:  max(int64(b.rate)-vals[1], 0),
+		ResetAt:    now.Add(time.Duration(vals[3]) * time.Millisecond),
+		RetryAfter: time.Duration(vals[2]) * time.Millisecond,
+	}, nil
 }
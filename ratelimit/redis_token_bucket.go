@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+//go:embed lua/token_bucket.lua
+var luaTokenBucketScript string
+
+//go:embed lua/token_bucket_refund.lua
+var luaTokenBucketRefundScript string
+
+type redisTokenBucketLimiter struct {
+	client redis.Cmdable
+	// 多久产生一个令牌
+	interval time.Duration
+	// 令牌桶容量，也就是允许的突发
+	capacity int
+	clock    Clock
+}
+
+// NewRedisTokenBucketLimiter interval 多久产生一个令牌, capacity 令牌数最大限度
+// 每个 key 一个令牌桶，新的 key 令牌桶是满的，令牌在 lua 脚本里面按照时间差惰性补充
+// 参数的限制和 NewTokenBucketLimiter 一样
+func NewRedisTokenBucketLimiter(client redis.Cmdable, interval time.Duration, capacity int, opts ...Option) Limiter {
+	checkTokenBucket(interval, capacity)
+	o := newOptions(opts)
+	return &redisTokenBucketLimiter{
+		client:   client,
+		interval: interval,
+		capacity: capacity,
+		clock:    o.clock,
+	}
+}
+
+func (r *redisTokenBucketLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(r.Allow(ctx, key))
+}
+
+func (r *redisTokenBucketLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+func (r *redisTokenBucketLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := checkPermits(n); err != nil {
+		return Result{}, err
+	}
+	now := r.clock.Now()
+	vals, err := r.client.Eval(ctx, luaTokenBucketScript, []string{key},
+		r.interval.Microseconds(), r.capacity, now.UnixMicro(), n).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	// {是否限流, 剩余令牌数, 多少微秒之后可以重试, 多少微秒之后令牌桶装满}
+	return Result{
+		Allowed:    vals[0] == 0,
+		Limit:      int64(r.capacity),
+		Remaining:  vals[1],
+		ResetAt:    now.Add(time.Duration(vals[3]) * time.Microsecond),
+		RetryAfter: time.Duration(vals[2]) * time.Microsecond,
+	}, nil
+}
+
+func (r *redisTokenBucketLimiter) Refund(ctx context.Context, key string, n int64) error {
+	if err := checkPermits(n); err != nil {
+		return err
+	}
+	return r.client.Eval(ctx, luaTokenBucketRefundScript, []string{key},
+		r.capacity, n).Err()
+}
@@ -0,0 +1,386 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 规则支持的算法
+const (
+	AlgorithmFixWindow          = "fix_window"
+	AlgorithmSlidingWindow      = "sliding_window"
+	AlgorithmTokenBucket        = "token_bucket"
+	AlgorithmGCRA               = "gcra"
+	AlgorithmRedisFixWindow     = "redis_fix_window"
+	AlgorithmRedisSlidingWindow = "redis_sliding_window"
+	AlgorithmRedisTokenBucket   = "redis_token_bucket"
+	AlgorithmRedisGCRA          = "redis_gcra"
+)
+
+// Rule 一条限流规则，Window 内最多 Rate 个请求
+type Rule struct {
+	// 规则的唯一标识，更新规则的时候按照 Name 保留状态
+	Name string `json:"name"`
+
+	// 匹配条件，为空就是不限制
+	KeyPrefix string `json:"key_prefix"`
+	Method    string `json:"method"`
+	Tenant    string `json:"tenant"`
+
+	Algorithm string   `json:"algorithm"`
+	Rate      int64    `json:"rate"`
+	Window    Duration `json:"window"`
+	// 令牌桶和 GCRA 允许的突发，为 0 就是 Rate
+	Burst int64 `json:"burst"`
+	// 影子模式，只记录不限流
+	Shadow bool `json:"shadow"`
+}
+
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("规则名字不能为空")
+	}
+	switch r.Algorithm {
+	case AlgorithmFixWindow, AlgorithmSlidingWindow, AlgorithmTokenBucket, AlgorithmGCRA,
+		AlgorithmRedisFixWindow, AlgorithmRedisSlidingWindow, AlgorithmRedisTokenBucket, AlgorithmRedisGCRA:
+	default:
+		return fmt.Errorf("规则 %s 未知的算法 %s", r.Name, r.Algorithm)
+	}
+	if r.Rate <= 0 {
+		return fmt.Errorf("规则 %s rate 必须大于 0", r.Name)
+	}
+	if r.Window <= 0 {
+		return fmt.Errorf("规则 %s window 必须大于 0", r.Name)
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("规则 %s burst 不能小于 0", r.Name)
+	}
+	// 令牌桶和 GCRA 的 window / rate 就是每个请求的间隔，redis 上按照微秒计算
+	if time.Duration(r.Window)/time.Duration(r.Rate) < time.Microsecond {
+		return fmt.Errorf("规则 %s window / rate 不能小于 1µs", r.Name)
+	}
+	return nil
+}
+
+func (r Rule) match(ctx context.Context, key string) bool {
+	if r.KeyPrefix != "" && !strings.HasPrefix(key, r.KeyPrefix) {
+		return false
+	}
+	if r.Method != "" && r.Method != MethodFromContext(ctx) {
+		return false
+	}
+	if r.Tenant != "" && r.Tenant != TenantFromContext(ctx) {
+		return false
+	}
+	return true
+}
+
+func (r Rule) burst() int64 {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return r.Rate
+}
+
+// ttl 一个 key 多久没有请求之后，本地限流器的状态会恢复成初始值
+func (r Rule) ttl() time.Duration {
+	if r.Algorithm == AlgorithmTokenBucket {
+		// 令牌补满的时间
+		return time.Duration(r.Window) / time.Duration(r.Rate) * time.Duration(r.burst())
+	}
+	return time.Duration(r.Window)
+}
+
+// sameParams 阈值有没有变化
+func (r Rule) sameParams(other Rule) bool {
+	return r.Algorithm == other.Algorithm && r.Rate == other.Rate &&
+		r.Window == other.Window && r.burst() == other.burst()
+}
+
+// Duration 在 JSON 里面写成 "1s" 这种形式
+// 不支持数字，避免 "window": 1 被当成 1ns
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var val any
+	if err := json.Unmarshal(data, &val); err != nil {
+		return err
+	}
+	v, ok := val.(string)
+	if !ok {
+		return fmt.Errorf("非法的时间 %s，需要写成 \"1s\" 这种形式", string(data))
+	}
+	duration, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// ParseRules 规则是一个 JSON 数组
+func ParseRules(data []byte) ([]Rule, error) {
+	var rules []Rule
+	err := json.Unmarshal(data, &rules)
+	return rules, err
+}
+
+type ruleEntry struct {
+	rule    Rule
+	limiter Limiter
+	// 影子模式下不为 nil
+	shadow Limiter
+}
+
+// RuleLimiter 按照规则限流，第一条匹配上的规则生效，没有匹配上的请求直接放行
+// 所有的算法都是按照 key 分别计数的，本地的固定窗口、滑动窗口和令牌桶会给每个 key 创建一个限流器
+// 规则可以在运行期间整体替换，算法没变的规则会保留每个 key 的状态
+type RuleLimiter struct {
+	// 只有 redis 的算法需要
+	client redis.Cmdable
+	l      logger.Logger
+
+	// 创建限流器的时候传进去
+	opts []Option
+
+	entries atomic.Pointer[[]ruleEntry]
+	// 更新规则是串行的
+	mutex sync.Mutex
+
+	shadowCounter *prometheus.CounterVec
+}
+
+func NewRuleLimiter(client redis.Cmdable, l logger.Logger, opts ...Option) *RuleLimiter {
+	res := &RuleLimiter{
+		client: client,
+		l:      l,
+		opts:   opts,
+	}
+	res.entries.Store(&[]ruleEntry{})
+	return res
+}
+
+// ShadowMetrics 影子模式下本应被限流的次数，label 是规则名字
+func (r *RuleLimiter) ShadowMetrics(opts prometheus.CounterOpts) *RuleLimiter {
+	counter := prometheus.NewCounterVec(opts, []string{"name"})
+	prometheus.MustRegister(counter)
+	r.shadowCounter = counter
+	return r
+}
+
+func (r *RuleLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(r.Allow(ctx, key))
+}
+
+func (r *RuleLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+func (r *RuleLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	entry, ok := r.match(ctx, key)
+	if !ok {
+		return Result{Allowed: true}, nil
+	}
+	if entry.shadow != nil {
+		return AllowN(ctx, entry.shadow, r.key(entry.rule, key), n)
+	}
+	return AllowN(ctx, entry.limiter, r.key(entry.rule, key), n)
+}
+
+// Rules 当前生效的规则
+func (r *RuleLimiter) Rules() []Rule {
+	entries := *r.entries.Load()
+	res := make([]Rule, 0, len(entries))
+	for _, entry := range entries {
+		res = append(res, entry.rule)
+	}
+	return res
+}
+
+// Update 校验通过之后整体替换规则，校验失败的话原本的规则不受影响
+func (r *RuleLimiter) Update(rules []Rule) error {
+	names := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+		if _, ok := names[rule.Name]; ok {
+			return fmt.Errorf("规则 %s 重复了", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		if strings.HasPrefix(rule.Algorithm, "redis_") && r.client == nil {
+			return fmt.Errorf("规则 %s 需要 redis", rule.Name)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	olds := make(map[string]ruleEntry, len(rules))
+	for _, entry := range *r.entries.Load() {
+		olds[entry.rule.Name] = entry
+	}
+	entries := make([]ruleEntry, 0, len(rules))
+	for _, rule := range rules {
+		entry := ruleEntry{rule: rule, limiter: r.limiter(olds, rule)}
+		if rule.Shadow {
+			entry.shadow = NewShadowLimiter(rule.Name, entry.limiter, r.l).Metrics(r.shadowCounter)
+		}
+		entries = append(entries, entry)
+	}
+	r.entries.Store(&entries)
+	return nil
+}
+
+// Watch 先加载一次规则，加载失败直接返回。之后规则有变更就更新
+func (r *RuleLimiter) Watch(ctx context.Context, src RuleSource) error {
+	ch, err := src.Watch(ctx)
+	if err != nil {
+		return err
+	}
+	data, ok := <-ch
+	if !ok {
+		return errors.New("规则来源已经关闭")
+	}
+	if err = r.load(data); err != nil {
+		return err
+	}
+	go func() {
+		for data := range ch {
+			if er := r.load(data); er != nil {
+				// 保留原本的规则
+				r.l.Error("更新限流规则失败", logger.Error(er))
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *RuleLimiter) load(data []byte) error {
+	rules, err := ParseRules(data)
+	if err != nil {
+		return err
+	}
+	return r.Update(rules)
+}
+
+func (r *RuleLimiter) match(ctx context.Context, key string) (ruleEntry, bool) {
+	for _, entry := range *r.entries.Load() {
+		if entry.rule.match(ctx, key) {
+			return entry, true
+		}
+	}
+	return ruleEntry{}, false
+}
+
+// key 不同规则之间的状态互不影响，特别是在 redis 上
+func (r *RuleLimiter) key(rule Rule, key string) string {
+	return rule.Name + ":" + key
+}
+
+// limiter 算法没变就保留原本的状态，只调整阈值
+// 原本的限流器在新的规则生效之前还在处理请求，所以不能原地修改
+func (r *RuleLimiter) limiter(olds map[string]ruleEntry, rule Rule) Limiter {
+	old, ok := olds[rule.Name]
+	if ok && old.rule.Algorithm == rule.Algorithm {
+		if old.rule.sameParams(rule) {
+			return old.limiter
+		}
+		if ru, ok := old.limiter.(ruleUpdater); ok {
+			return ru.withRule(rule)
+		}
+	}
+	return r.newLimiter(rule)
+}
+
+// newLimiter redis 的限流器状态都在 redis 上，重新创建也不会丢
+func (r *RuleLimiter) newLimiter(rule Rule) Limiter {
+	window := time.Duration(rule.Window)
+	switch rule.Algorithm {
+	case AlgorithmFixWindow, AlgorithmSlidingWindow, AlgorithmTokenBucket:
+		return newKeyedLimiter(rule, r.newLocalLimiter, r.opts)
+	case AlgorithmGCRA:
+		return NewGCRALimiter(window, rule.Rate, rule.burst(), r.opts...)
+	case AlgorithmRedisFixWindow:
+		return NewRedisFixWindowLimiter(r.client, window, int(rule.Rate), r.opts...)
+	case AlgorithmRedisSlidingWindow:
+		return NewRedisSlidingWindowLimiter(r.client, window, int(rule.Rate), r.opts...)
+	case AlgorithmRedisTokenBucket:
+		return NewRedisTokenBucketLimiter(r.client, window/time.Duration(rule.Rate), int(rule.burst()), r.opts...)
+	case AlgorithmRedisGCRA:
+		return NewRedisGCRALimiter(r.client, window, int(rule.Rate), int(rule.burst()), r.opts...)
+	}
+	// Validate 保证了不会走到这里
+	panic("未知的算法 " + rule.Algorithm)
+}
+
+// newLocalLimiter 只有一份状态的本地限流器，keyedLimiter 给每个 key 创建一个
+func (r *RuleLimiter) newLocalLimiter(rule Rule) Limiter {
+	window := time.Duration(rule.Window)
+	switch rule.Algorithm {
+	case AlgorithmFixWindow:
+		return NewFixWindowLimiter(window, rule.Rate, r.opts...)
+	case AlgorithmSlidingWindow:
+		return NewSlidingWindowLimiter(window, rule.Rate, r.opts...)
+	default:
+		return NewTokenBucketLimiter(window/time.Duration(rule.Rate), int(rule.burst()), r.opts...)
+	}
+}
+
+// ruleUpdater 本地的限流器状态在内存里，按照新的规则创建一个共用状态的限流器
+type ruleUpdater interface {
+	withRule(rule Rule) Limiter
+}
+
+// reconfigurable 只有一份状态的本地限流器，由 keyedLimiter 原地调整阈值
+type reconfigurable interface {
+	reconfigure(rule Rule)
+}
+
+func (f *fixWindowLimiter) reconfigure(rule Rule) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.interval = time.Duration(rule.Window)
+	f.rate = rule.Rate
+}
+
+func (s *slidingWindowLimiter) reconfigure(rule Rule) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.interval = time.Duration(rule.Window)
+	s.rate = rule.Rate
+}
+
+func (t *tokenBucketLimiter) reconfigure(rule Rule) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// 先按照原本的速率把令牌补上
+	t.refill(t.clock.Now())
+	t.interval = time.Duration(rule.Window) / time.Duration(rule.Rate)
+	t.capacity = rule.burst()
+	t.tokens = min(t.tokens, t.capacity)
+}
+
+// withRule 和原本的限流器共用每个 key 的 TAT
+func (g *gcraLimiter) withRule(rule Rule) Limiter {
+	res := &gcraLimiter{
+		idleTimeout: g.idleTimeout,
+		shards:      g.shards,
+		clock:       g.clock,
+	}
+	res.params.Store(newGCRAParams(time.Duration(rule.Window), rule.Rate, rule.burst()))
+	return res
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+)
+
+// RuleSource 规则的来源，例如 文件、etcd
+type RuleSource interface {
+	// Watch 先推送一次当前的规则，之后每次变更推送一次，ctx 结束之后关闭 channel
+	Watch(ctx context.Context) (<-chan []byte, error)
+}
+
+// fileRuleSource 定时检查文件内容有没有变化
+type fileRuleSource struct {
+	path     string
+	interval time.Duration
+}
+
+func NewFileRuleSource(path string, interval time.Duration) RuleSource {
+	return &fileRuleSource{
+		path:     path,
+		interval: interval,
+	}
+}
+
+func (f *fileRuleSource) Watch(ctx context.Context) (<-chan []byte, error) {
+	last, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []byte, 1)
+	ch <- last
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				data, er := os.ReadFile(f.path)
+				// 读失败了，比如文件正在被替换，下一次再读
+				if er != nil || bytes.Equal(data, last) {
+					continue
+				}
+				last = data
+				select {
+				case ch <- data:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// ShadowLimiter 影子模式，正常判断限流，但是只记录本应被限流的请求，永远放行
+// 用来在线上调整阈值，确认没问题之后再真正开启限流
+type ShadowLimiter struct {
+	limiter Limiter
+	name    string
+	// base 是没有采样的 logger，Sample 的时候基于它重新创建
+	base logger.Logger
+	l    logger.Logger
+	// 本应被限流的次数，label 是 name
+	counter *prometheus.CounterVec
+}
+
+// NewShadowLimiter 真的过载的时候每个请求都会触发，所以日志默认是采样的：
+// 每秒前 10 条都会输出，之后每 100 条输出一条，准确的次数看 Metrics
+func NewShadowLimiter(name string, limiter Limiter, l logger.Logger) *ShadowLimiter {
+	return &ShadowLimiter{
+		limiter: limiter,
+		name:    name,
+		base:    l,
+		l:       logger.NewSamplingLogger(l, time.Second, 10, 100),
+	}
+}
+
+// Sample 调整日志的采样，每个 interval 内前 first 条都会输出，之后每 thereafter 条输出一条
+// thereafter 为 0 的时候，超过 first 条之后全部丢弃
+func (s *ShadowLimiter) Sample(interval time.Duration, first, thereafter uint64) *ShadowLimiter {
+	s.l = logger.NewSamplingLogger(s.base, interval, first, thereafter)
+	return s
+}
+
+// Metrics counter 只有一个 label: name，多个影子限流器可以共用一个 counter
+func (s *ShadowLimiter) Metrics(counter *prometheus.CounterVec) *ShadowLimiter {
+	s.counter = counter
+	return s
+}
+
+func (s *ShadowLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(s.Allow(ctx, key))
+}
+
+func (s *ShadowLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return s.AllowN(ctx, key, 1)
+}
+
+func (s *ShadowLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	res, err := AllowN(ctx, s.limiter, key, n)
+	if err != nil {
+		s.l.ErrorCtx(ctx, "影子限流判断出现问题",
+			logger.String("name", s.name),
+			logger.String("key", key),
+			logger.Error(err))
+		return Result{Allowed: true}, nil
+	}
+	if !res.Allowed {
+		s.l.WarnCtx(ctx, "影子限流触发限流，已放行",
+			logger.String("name", s.name),
+			logger.String("key", key),
+			logger.Int64("remaining", res.Remaining),
+			logger.Int64("retry_after_ms", res.RetryAfter.Milliseconds()))
+		if s.counter != nil {
+			s.counter.WithLabelValues(s.name).Inc()
+		}
+		res.Allowed = true
+		res.RetryAfter = 0
+	}
+	return res, nil
+}
@@ -0,0 +1,324 @@
+package ratelimit_test
+
+import (
+	"context"
+	"github.com/DaHuangQwQ/gpkg/ratelimit"
+	"github.com/DaHuangQwQ/gpkg/ratelimit/ratelimittest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSimulation(t *testing.T) {
+	testCases := []struct {
+		name    string
+		limiter func(clock ratelimit.Clock) ratelimit.Limiter
+		trace   []ratelimittest.Arrival
+	}{
+		{
+			name: "fix window",
+			limiter: func(clock ratelimit.Clock) ratelimit.Limiter {
+				return ratelimit.NewFixWindowLimiter(time.Second, 3, ratelimit.WithClock(clock))
+			},
+			trace: append(ratelimittest.Every(0, time.Millisecond*100, "a", "+++-"),
+				// 进入下一个窗口
+				ratelimittest.Arrival{At: time.Millisecond * 1100, Key: "a", Allowed: true}),
+		},
+		{
+			name: "sliding window",
+			limiter: func(clock ratelimit.Clock) ratelimit.Limiter {
+				return ratelimit.NewSlidingWindowLimiter(time.Second, 2, ratelimit.WithClock(clock))
+			},
+			trace: []ratelimittest.Arrival{
+				{At: 0, Key: "a", Allowed: true},
+				{At: time.Millisecond * 400, Key: "a", Allowed: true},
+				{At: time.Millisecond * 800, Key: "a", Allowed: false},
+				// 0 滑出窗口
+				{At: time.Millisecond * 1000, Key: "a", Allowed: true},
+				{At: time.Millisecond * 1200, Key: "a", Allowed: false},
+				// 400 滑出窗口
+				{At: time.Millisecond * 1400, Key: "a", Allowed: true},
+			},
+		},
+		{
+			name: "token bucket",
+			limiter: func(clock ratelimit.Clock) ratelimit.Limiter {
+				return ratelimit.NewTokenBucketLimiter(time.Millisecond*100, 2, ratelimit.WithClock(clock))
+			},
+			trace: []ratelimittest.Arrival{
+				// 一开始令牌桶是满的
+				{At: 0, Key: "a", Allowed: true},
+				{At: 0, Key: "a", Allowed: true},
+				{At: 0, Key: "a", Allowed: false},
+				{At: time.Millisecond * 100, Key: "a", Allowed: true},
+				{At: time.Millisecond * 150, Key: "a", Allowed: false},
+				// 补充了两个令牌
+				{At: time.Millisecond * 300, Key: "a", Allowed: true},
+				{At: time.Millisecond * 300, Key: "a", Allowed: true},
+				{At: time.Millisecond * 300, Key: "a", Allowed: false},
+				{At: time.Millisecond * 400, Key: "a", N: 2, Allowed: false},
+				{At: time.Millisecond * 500, Key: "a", N: 2, Allowed: true},
+			},
+		},
+		{
+			name: "gcra",
+			limiter: func(clock ratelimit.Clock) ratelimit.Limiter {
+				return ratelimit.NewGCRALimiter(time.Second, 10, 2, ratelimit.WithClock(clock))
+			},
+			trace: gcraTrace(),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := ratelimittest.NewManualClock(time.Unix(1700000000, 0))
+			ratelimittest.NewSimulation(clock).Run(t, tc.limiter(clock), tc.trace)
+		})
+	}
+}
+
+func TestRedisSimulation(t *testing.T) {
+	testCases := []struct {
+		name    string
+		limiter func(client redis.Cmdable, clock ratelimit.Clock) ratelimit.Limiter
+		trace   []ratelimittest.Arrival
+	}{
+		{
+			name: "fix window",
+			limiter: func(client redis.Cmdable, clock ratelimit.Clock) ratelimit.Limiter {
+				return ratelimit.NewRedisFixWindowLimiter(client, time.Second, 3, ratelimit.WithClock(clock))
+			},
+			trace: append(ratelimittest.Every(0, time.Millisecond*100, "a", "+++-"),
+				// key 过期，进入下一个窗口
+				ratelimittest.Arrival{At: time.Millisecond * 1100, Key: "a", Allowed: true},
+				ratelimittest.Arrival{At: time.Millisecond * 1100, Key: "a", N: 3, Allowed: false},
+				ratelimittest.Arrival{At: time.Millisecond * 1100, Key: "a", N: 2, Allowed: true}),
+		},
+		{
+			name: "sliding window",
+			limiter: func(client redis.Cmdable, clock ratelimit.Clock) ratelimit.Limiter {
+				return ratelimit.NewRedisSlidingWindowLimiter(client, time.Second, 2, ratelimit.WithClock(clock))
+			},
+			trace: []ratelimittest.Arrival{
+				{At: 0, Key: "a", Allowed: true},
+				// 同一毫秒的请求也要分别计数
+				{At: 0, Key: "b", Allowed: true},
+				{At: 0, Key: "b", Allowed: true},
+				{At: 0, Key: "b", Allowed: false},
+				{At: time.Millisecond * 400, Key: "a", Allowed: true},
+				{At: time.Millisecond * 800, Key: "a", Allowed: false},
+				{At: time.Millisecond * 1000, Key: "a", Allowed: true},
+				{At: time.Millisecond * 1200, Key: "a", Allowed: false},
+				{At: time.Millisecond * 1400, Key: "a", Allowed: true},
+			},
+		},
+		{
+			name: "token bucket",
+			limiter: func(client redis.Cmdable, clock ratelimit.Clock) ratelimit.Limiter {
+				return ratelimit.NewRedisTokenBucketLimiter(client, time.Millisecond*100, 2, ratelimit.WithClock(clock))
+			},
+			trace: []ratelimittest.Arrival{
+				// 新的 key 令牌桶是满的
+				{At: 0, Key: "a", Allowed: true},
+				{At: 0, Key: "a", Allowed: true},
+				{At: 0, Key: "a", Allowed: false},
+				{At: time.Millisecond * 100, Key: "a", Allowed: true},
+				{At: time.Millisecond * 150, Key: "a", Allowed: false},
+				{At: time.Millisecond * 300, Key: "a", Allowed: true},
+				{At: time.Millisecond * 300, Key: "a", Allowed: true},
+				{At: time.Millisecond * 300, Key: "a", Allowed: false},
+				{At: time.Millisecond * 400, Key: "a", N: 2, Allowed: false},
+				{At: time.Millisecond * 500, Key: "a", N: 2, Allowed: true},
+			},
+		},
+		{
+			name: "gcra",
+			limiter: func(client redis.Cmdable, clock ratelimit.Clock) ratelimit.Limiter {
+				return ratelimit.NewRedisGCRALimiter(client, time.Second, 10, 2, ratelimit.WithClock(clock))
+			},
+			trace: gcraTrace(),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			clock := ratelimittest.NewManualClock(time.Unix(1700000000, 0))
+			sim := ratelimittest.NewSimulation(clock)
+			sim.OnAdvance = mr.FastForward
+			sim.Run(t, tc.limiter(client, clock), tc.trace)
+		})
+	}
+}
+
+// gcraTrace 每 100ms 一个请求，突发 2 个
+func gcraTrace() []ratelimittest.Arrival {
+	return []ratelimittest.Arrival{
+		{At: 0, Key: "a", Allowed: true},
+		{At: 0, Key: "a", Allowed: true},
+		{At: 0, Key: "a", Allowed: false},
+		// 不同的 key 互不影响
+		{At: 0, Key: "b", Allowed: true},
+		{At: time.Millisecond * 100, Key: "a", Allowed: true},
+		{At: time.Millisecond * 150, Key: "a", Allowed: false},
+		{At: time.Millisecond * 200, Key: "a", Allowed: true},
+		{At: time.Millisecond * 500, Key: "a", N: 3, Allowed: false},
+		{At: time.Millisecond * 500, Key: "a", N: 2, Allowed: true},
+	}
+}
+
+func TestRedisConcurrencyLimiter(t *testing.T) {
+	_, err := ratelimit.NewRedisConcurrencyLimiter(nil, 1, 0)
+	assert.EqualError(t, err, "ratelimit: lease 不能小于 1ms，实际是 0s")
+	_, err = ratelimit.NewRedisConcurrencyLimiter(nil, 1, time.Microsecond*999)
+	assert.EqualError(t, err, "ratelimit: lease 不能小于 1ms，实际是 999µs")
+	_, err = ratelimit.NewRedisConcurrencyLimiter(nil, 0, time.Second)
+	assert.EqualError(t, err, "ratelimit: capacity 必须大于 0，实际是 0")
+
+	const key = "concurrency"
+	ctx := context.Background()
+	setup := func(t *testing.T, capacity int) (ratelimit.AcquireLimiter, *miniredis.Miniredis, *ratelimittest.ManualClock) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		clock := ratelimittest.NewManualClock(time.UnixMilli(1700000000000))
+		limiter, err := ratelimit.NewRedisConcurrencyLimiter(client, capacity, time.Second*3, ratelimit.WithClock(clock))
+		require.NoError(t, err)
+		return limiter, mr, clock
+	}
+	advance := func(mr *miniredis.Miniredis, clock *ratelimittest.ManualClock, d time.Duration) {
+		clock.Add(d)
+		mr.FastForward(d)
+	}
+
+	t.Run("占用和释放", func(t *testing.T) {
+		limiter, _, _ := setup(t, 2)
+		done1, err := limiter.Acquire(ctx, key)
+		require.NoError(t, err)
+		done2, err := limiter.Acquire(ctx, key)
+		require.NoError(t, err)
+		defer done2()
+		_, err = limiter.Acquire(ctx, key)
+		require.ErrorIs(t, err, ratelimit.ErrLimitExceeded)
+		limited, err := limiter.Limit(ctx, key)
+		require.ErrorIs(t, err, ratelimit.ErrLimitExceeded)
+		require.True(t, limited)
+
+		done1()
+		// 重复调用只会释放一次
+		done1()
+		limited, err = limiter.Limit(ctx, key)
+		require.NoError(t, err)
+		require.False(t, limited)
+		done3, err := limiter.Acquire(ctx, key)
+		require.NoError(t, err)
+		done3()
+	})
+
+	t.Run("崩溃的持有者租约过期", func(t *testing.T) {
+		limiter, mr, clock := setup(t, 1)
+		// 另一个进程拿到信号量之后崩溃了，不会续约也不会释放
+		_, err := mr.ZAdd(key, float64(clock.Now().Add(time.Second*3).UnixMilli()), "crashed")
+		require.NoError(t, err)
+		_, err = limiter.Acquire(ctx, key)
+		require.ErrorIs(t, err, ratelimit.ErrLimitExceeded)
+
+		advance(mr, clock, time.Second*3+time.Millisecond)
+		done, err := limiter.Acquire(ctx, key)
+		require.NoError(t, err)
+		done()
+	})
+
+	t.Run("持有期间自动续约", func(t *testing.T) {
+		limiter, mr, clock := setup(t, 1)
+		done, err := limiter.Acquire(ctx, key)
+		require.NoError(t, err)
+		members, err := mr.ZMembers(key)
+		require.NoError(t, err)
+		require.Len(t, members, 1)
+		// 远远超过最初的租约
+		for i := 0; i < 5; i++ {
+			advance(mr, clock, time.Second)
+			expireAt := float64(clock.Now().Add(time.Second * 3).UnixMilli())
+			require.Eventually(t, func() bool {
+				score, er := mr.ZScore(key, members[0])
+				return er == nil && score == expireAt
+			}, time.Second, time.Millisecond)
+		}
+		_, err = limiter.Acquire(ctx, key)
+		require.ErrorIs(t, err, ratelimit.ErrLimitExceeded)
+
+		// 释放之后不会再被续约加回去
+		done()
+		advance(mr, clock, time.Second)
+		members, _ = mr.ZMembers(key)
+		assert.Empty(t, members)
+		done, err = limiter.Acquire(ctx, key)
+		require.NoError(t, err)
+		done()
+	})
+}
+
+func TestLeakyBucketClock(t *testing.T) {
+	clock := ratelimittest.NewManualClock(time.UnixMilli(1700000000000))
+	limiter := ratelimit.NewLeakyBucketLimiter(time.Millisecond*100, ratelimit.WithClock(clock))
+	defer limiter.(interface{ Close() error }).Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := ratelimit.AllowN(context.Background(), limiter, "a", 3)
+		done <- err
+	}()
+	elapsed, err := advanceUntil(clock, time.Millisecond*10, done)
+	require.NoError(t, err)
+	// 漏出 3 个请求
+	assert.GreaterOrEqual(t, elapsed, time.Millisecond*300)
+}
+
+func TestWaitClock(t *testing.T) {
+	testCases := []struct {
+		name    string
+		limiter func(clock ratelimit.Clock) ratelimit.Limiter
+	}{
+		{
+			name: "reserve",
+			limiter: func(clock ratelimit.Clock) ratelimit.Limiter {
+				return ratelimit.NewGCRALimiter(time.Second, 1, 1, ratelimit.WithClock(clock))
+			},
+		},
+		{
+			name: "retry",
+			limiter: func(clock ratelimit.Clock) ratelimit.Limiter {
+				return ratelimit.NewSlidingWindowLimiter(time.Second, 1, ratelimit.WithClock(clock))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := ratelimittest.NewManualClock(time.UnixMilli(1700000000000))
+			limiter := tc.limiter(clock)
+			require.NoError(t, ratelimit.Wait(context.Background(), limiter, "a", ratelimit.WithClock(clock)))
+			done := make(chan error, 1)
+			go func() {
+				done <- ratelimit.Wait(context.Background(), limiter, "a", ratelimit.WithClock(clock))
+			}()
+			elapsed, err := advanceUntil(clock, time.Millisecond*100, done)
+			require.NoError(t, err)
+			// 真实时间远远不到 1s，只能是按照时钟等待的
+			assert.GreaterOrEqual(t, elapsed, time.Second)
+		})
+	}
+}
+
+// advanceUntil 不停地推进时钟直到 done 返回，返回时钟一共推进了多久
+func advanceUntil(clock *ratelimittest.ManualClock, step time.Duration, done <-chan error) (time.Duration, error) {
+	start := clock.Now()
+	for {
+		select {
+		case err := <-done:
+			return clock.Now().Sub(start), err
+		case <-time.After(time.Millisecond):
+			clock.Add(step)
+		}
+	}
+}
@@ -14,41 +14,98 @@ type slidingWindowLimiter struct {
 	rate     int64
 
 	queue *list.List
+	// 窗口内消耗的配额
+	cnt int64
 
+	clock Clock
 	mutex sync.Mutex
 }
 
-func NewSlidingWindowLimiter(interval time.Duration, rate int64) Limiter {
+// slideEntry 一次请求的时间和消耗的配额
+type slideEntry struct {
+	timestamp int64
+	n         int64
+}
+
+func NewSlidingWindowLimiter(interval time.Duration, rate int64, opts ...Option) Limiter {
+	o := newOptions(opts)
 	return &slidingWindowLimiter{
 		interval: interval,
 		rate:     rate,
 		queue:    list.New(),
+		clock:    o.clock,
 		mutex:    sync.Mutex{},
 	}
 }
 
 func (s *slidingWindowLimiter) Limit(ctx context.Context, key string) (bool, error) {
-	now := time.Now().UnixNano()
-	// 快路径
-	if int64(s.queue.Len()) < s.rate {
-		s.queue.PushBack(now)
-		return false, nil
+	return limitOf(s.Allow(ctx, key))
+}
+
+func (s *slidingWindowLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return s.AllowN(ctx, key, 1)
+}
+
+func (s *slidingWindowLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := checkPermits(n); err != nil {
+		return Result{}, err
 	}
+	now := s.clock.Now().UnixNano()
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	boundary := now - int64(s.interval)
-	timestamp := s.queue.Front()
-	for timestamp != nil && timestamp.Value.(int64) <= boundary {
-		s.queue.Remove(timestamp)
-		timestamp = s.queue.Front()
+	front := s.queue.Front()
+	for front != nil && front.Value.(slideEntry).timestamp <= boundary {
+		s.cnt -= front.Value.(slideEntry).n
+		s.queue.Remove(front)
+		front = s.queue.Front()
 	}
 
-	if int64(s.queue.Len()) > s.rate {
-		return true, ErrLimitExceeded
+	res := Result{Limit: s.rate, Remaining: max(s.rate-s.cnt, 0)}
+	if s.cnt+n > s.rate {
+		// 前面的请求滑出窗口，腾出足够的配额之后就可以重试
+		need := s.cnt + n - s.rate
+		for e := s.queue.Front(); e != nil; e = e.Next() {
+			entry := e.Value.(slideEntry)
+			need -= entry.n
+			if need <= 0 {
+				res.RetryAfter = time.Duration(entry.timestamp + int64(s.interval) - now)
+				break
+			}
+		}
+		if back := s.queue.Back(); back != nil {
+			res.ResetAt = time.Unix(0, back.Value.(slideEntry).timestamp+int64(s.interval))
+		}
+		return res, nil
 	}
 
-	s.queue.PushBack(now)
-	return false, nil
+	s.queue.PushBack(slideEntry{timestamp: now, n: n})
+	s.cnt += n
+	res.Allowed = true
+	res.Remaining = s.rate - s.cnt
+	res.ResetAt = time.Unix(0, now+int64(s.interval))
+	return res, nil
+}
+
+// Refund 从最新的请求开始还
+func (s *slidingWindowLimiter) Refund(ctx context.Context, key string, n int64) error {
+	if err := checkPermits(n); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for back := s.queue.Back(); back != nil && n > 0; back = s.queue.Back() {
+		entry := back.Value.(slideEntry)
+		if entry.n > n {
+			back.Value = slideEntry{timestamp: entry.timestamp, n: entry.n - n}
+			s.cnt -= n
+			return nil
+		}
+		s.queue.Remove(back)
+		s.cnt -= entry.n
+		n -= entry.n
+	}
+	return nil
 }
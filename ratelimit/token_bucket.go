@@ -3,55 +3,157 @@ package ratelimit
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 )
 
 type tokenBucketLimiter struct {
-	tokens  chan struct{}
+	// 多久产生一个令牌
+	interval time.Duration
+	capacity int64
+
+	// 当前的令牌数，有预留的时候会是负数
+	tokens int64
+	// 上一次补充令牌的时间
+	last time.Time
+
+	clock   Clock
 	closeCh chan struct{}
+	mutex   sync.Mutex
 }
 
 // NewTokenBucketLimiter interval 多久产生一个令牌, capacity 令牌数最大限度
-func NewTokenBucketLimiter(interval time.Duration, capacity int) Limiter {
-	ch := make(chan struct{}, capacity)
-	ticker := time.NewTicker(interval)
-	closeCh := make(chan struct{})
-
-	go func() {
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				// 令牌满了 丢掉
-				select {
-				case ch <- struct{}{}:
-				default:
-				}
-			case <-closeCh:
-				return
-			}
-		}
-	}()
-
+// 和 redis 的实现一样，新的令牌桶是满的
+// interval 不能小于 1µs，capacity 必须大于 0，否则 panic
+func NewTokenBucketLimiter(interval time.Duration, capacity int, opts ...Option) Limiter {
+	checkTokenBucket(interval, capacity)
+	o := newOptions(opts)
 	return &tokenBucketLimiter{
-		tokens:  ch,
-		closeCh: closeCh,
+		interval: interval,
+		capacity: int64(capacity),
+		tokens:   int64(capacity),
+		last:     o.clock.Now(),
+		clock:    o.clock,
+		closeCh:  make(chan struct{}),
+	}
+}
+
+// checkTokenBucket 参数不合法是编码错误，直接 panic
+func checkTokenBucket(interval time.Duration, capacity int) {
+	if interval < time.Microsecond {
+		// redis 的实现按照微秒计算，不足 1µs 会变成 0
+		panic(fmt.Sprintf("ratelimit: interval 不能小于 1µs，实际是 %s", interval))
+	}
+	if capacity < 1 {
+		panic(fmt.Sprintf("ratelimit: capacity 必须大于 0，实际是 %d", capacity))
 	}
 }
 
 func (t *tokenBucketLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	return limitOf(t.Allow(ctx, key))
+}
+
+func (t *tokenBucketLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return t.AllowN(ctx, key, 1)
+}
+
+func (t *tokenBucketLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if err := checkPermits(n); err != nil {
+		return Result{}, err
+	}
+	if err := t.check(ctx); err != nil {
+		return Result{}, err
+	}
+	now := t.clock.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.refill(now)
+	res := Result{Limit: t.capacity}
+	if t.tokens < n {
+		res.Remaining = max(t.tokens, 0)
+		res.ResetAt = t.fullAt()
+		// 等到令牌数凑够 n 个
+		res.RetryAfter = t.last.Add(time.Duration(n-t.tokens) * t.interval).Sub(now)
+		return res, nil
+	}
+	t.tokens -= n
+	res.Allowed = true
+	res.Remaining = t.tokens
+	res.ResetAt = t.fullAt()
+	return res, nil
+}
+
+// Reserve 令牌不够的时候可以先欠着，等补充够了再用
+func (t *tokenBucketLimiter) Reserve(ctx context.Context, key string, n int64) (*Reservation, error) {
+	if err := checkPermits(n); err != nil {
+		return nil, err
+	}
+	if err := t.check(ctx); err != nil {
+		return nil, err
+	}
+	if n > t.capacity {
+		// 永远不可能凑够
+		return &Reservation{}, nil
+	}
+	now := t.clock.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.refill(now)
+	t.tokens -= n
+	timeToAct := now
+	if t.tokens < 0 {
+		timeToAct = t.last.Add(time.Duration(-t.tokens) * t.interval)
+	}
+	return newReservation(t.clock, timeToAct, func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		t.tokens = min(t.tokens+n, t.capacity)
+	}), nil
+}
+
+func (t *tokenBucketLimiter) Refund(ctx context.Context, key string, n int64) error {
+	if err := checkPermits(n); err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.tokens = min(t.tokens+n, t.capacity)
+	return nil
+}
+
+func (t *tokenBucketLimiter) check(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
-		return true, ctx.Err()
+		return ctx.Err()
 	case <-t.closeCh:
-		return true, errors.New("close channel closed")
-	case <-t.tokens:
-		return false, nil
+		return errors.New("close channel closed")
 	default:
-		return true, ErrLimitExceeded
+		return nil
 	}
 }
 
+// refill 按照时间差补充令牌
+func (t *tokenBucketLimiter) refill(now time.Time) {
+	delta := int64(now.Sub(t.last) / t.interval)
+	if delta > 0 {
+		t.tokens = min(t.tokens+delta, t.capacity)
+		t.last = t.last.Add(time.Duration(delta) * t.interval)
+	}
+	if t.tokens >= t.capacity {
+		// 令牌满了 丢掉
+		t.last = now
+	}
+}
+
+func (t *tokenBucketLimiter) fullAt() time.Time {
+	return t.last.Add(time.Duration(t.capacity-t.tokens) * t.interval)
+}
+
 func (t *tokenBucketLimiter) Close() error {
 	close(t.closeCh)
 	return nil
@@ -3,15 +3,107 @@ package ratelimit
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 )
 
 type Limiter interface {
 	// Limit key 是限流对象， 一般是ip地址
 	// Limit 是否触发限流
-	// 返回 true，就是触发限流
+	// 返回 true，就是触发限流，此时 error 是 ErrLimitExceeded
+	// 限流器本身出了问题的时候返回 true 和对应的 error
 	Limit(ctx context.Context, key string) (bool, error)
 }
 
+// ResultLimiter 除了是否限流，还能告诉调用者剩余配额和什么时候可以重试
+type ResultLimiter interface {
+	Limiter
+	// Allow 被限流的时候 Result.Allowed 为 false，error 只用来表示限流器本身出了问题
+	Allow(ctx context.Context, key string) (Result, error)
+	// AllowN 一次消耗 n 个配额，要么全部拿到，要么一个都不拿
+	AllowN(ctx context.Context, key string, n int64) (Result, error)
+}
+
+// DoneFunc 请求处理完之后调用
+type DoneFunc func()
+
+// Acquirer 需要知道请求什么时候处理完的限流器，例如自适应限流
+type Acquirer interface {
+	// Acquire 被限流的时候返回 ErrLimitExceeded，放行之后必须调用 DoneFunc
+	Acquire(ctx context.Context, key string) (DoneFunc, error)
+}
+
+// AcquireLimiter 并发限流、自适应限流这种要统计在途请求的限流器
+// 它们的 Limit 只是查看当前的状态，不会占用在途请求数，只调用 Limit 永远不会真正限流，
+// 必须通过 Acquire 使用，例如 grpcx 的 AdaptiveInterceptorBuilder
+type AcquireLimiter interface {
+	Limiter
+	Acquirer
+}
+
+// Result 一次限流判断的结果
+type Result struct {
+	// 是否放行
+	Allowed bool
+	// 阈值
+	Limit int64
+	// 剩余的配额
+	Remaining int64
+	// 配额完全恢复的时间
+	ResetAt time.Time
+	// 被限流之后，多久可以重试
+	RetryAfter time.Duration
+}
+
 var (
 	ErrLimitExceeded = errors.New("rate limit exceeded")
+	// ErrInvalidPermits 一次消耗的配额必须大于 0，负数相当于凭空产生配额
+	ErrInvalidPermits = errors.New("ratelimit: 配额数必须大于 0")
+	// ErrPermitsNotSupported 没有实现 ResultLimiter 的限流器只能一次消耗一个配额
+	ErrPermitsNotSupported = errors.New("ratelimit: 限流器不支持一次消耗多个配额")
 )
+
+// Allow 如果 limiter 没有实现 ResultLimiter，就只能拿到是否放行
+func Allow(ctx context.Context, limiter Limiter, key string) (Result, error) {
+	return AllowN(ctx, limiter, key, 1)
+}
+
+// AllowN 没有实现 ResultLimiter 的限流器不支持权重，n 大于 1 的时候返回 ErrPermitsNotSupported
+func AllowN(ctx context.Context, limiter Limiter, key string, n int64) (Result, error) {
+	if err := checkPermits(n); err != nil {
+		return Result{}, err
+	}
+	if rl, ok := limiter.(ResultLimiter); ok {
+		return rl.AllowN(ctx, key, n)
+	}
+	if n > 1 {
+		// 只扣一个配额会让贵的请求少算，宁可报错
+		return Result{}, fmt.Errorf("%w: %T, n = %d", ErrPermitsNotSupported, limiter, n)
+	}
+	limited, err := limiter.Limit(ctx, key)
+	if errors.Is(err, ErrLimitExceeded) {
+		return Result{}, nil
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Allowed: !limited}, nil
+}
+
+func checkPermits(n int64) error {
+	if n < 1 {
+		return fmt.Errorf("%w，实际是 %d", ErrInvalidPermits, n)
+	}
+	return nil
+}
+
+// limitOf 所有实现了 ResultLimiter 的限流器都通过它实现 Limit，被限流的时候返回 ErrLimitExceeded
+func limitOf(res Result, err error) (bool, error) {
+	if err != nil {
+		return true, err
+	}
+	if !res.Allowed {
+		return true, ErrLimitExceeded
+	}
+	return false, nil
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Reserver 可以预留配额的限流器
+type Reserver interface {
+	// Reserve 预留 n 个配额，配额不够的时候不会失败，而是告诉调用者要等多久
+	Reserve(ctx context.Context, key string, n int64) (*Reservation, error)
+}
+
+// Reservation 预留的配额
+type Reservation struct {
+	ok    bool
+	clock Clock
+	// 什么时候可以用
+	timeToAct time.Time
+	cancel    func()
+	once      sync.Once
+}
+
+func newReservation(clock Clock, timeToAct time.Time, cancel func()) *Reservation {
+	return &Reservation{
+		ok:        true,
+		clock:     clock,
+		timeToAct: timeToAct,
+		cancel:    cancel,
+	}
+}
+
+// OK 是否预留成功，例如 n 超过了突发上限就永远不会成功
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay 还要等多久才可以用预留的配额
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return time.Duration(1<<63 - 1)
+	}
+	return max(r.timeToAct.Sub(r.clock.Now()), 0)
+}
+
+// Cancel 不用了，把配额还回去。多次调用只会还一次
+func (r *Reservation) Cancel() {
+	if !r.ok || r.cancel == nil {
+		return
+	}
+	r.once.Do(r.cancel)
+}
+
+// 不支持预留的限流器，被限流了又没有告诉我们重试时间，就按照这个间隔重试
+const defaultWaitInterval = 10 * time.Millisecond
+
+// Wait 阻塞直到拿到一个配额，或者 ctx 过期
+// 支持预留的限流器会预留配额，按照限流器自己的时钟等待
+// 其余的限流器按照 Result.RetryAfter 重试，限流器用了 WithClock 的时候要传入同一个时钟
+func Wait(ctx context.Context, limiter Limiter, key string, opts ...Option) error {
+	if r, ok := limiter.(Reserver); ok {
+		return waitReservation(ctx, r, key)
+	}
+	clock := newOptions(opts).clock
+	for {
+		res, err := Allow(ctx, limiter, key)
+		if err != nil {
+			return err
+		}
+		if res.Allowed {
+			return nil
+		}
+		delay := res.RetryAfter
+		if delay <= 0 {
+			delay = defaultWaitInterval
+		}
+		if err = sleep(ctx, clock, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func waitReservation(ctx context.Context, r Reserver, key string) error {
+	rv, err := r.Reserve(ctx, key, 1)
+	if err != nil {
+		return err
+	}
+	if !rv.OK() {
+		return ErrLimitExceeded
+	}
+	delay := rv.Delay()
+	if delay == 0 {
+		return nil
+	}
+	// 等不到就不要占着配额
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(rv.clock.Now()) < delay {
+		rv.Cancel()
+		return context.DeadlineExceeded
+	}
+	if err = sleep(ctx, rv.clock, delay); err != nil {
+		rv.Cancel()
+		return err
+	}
+	return nil
+}
+
+// sleep 只用 ticker 的第一次触发，相当于 clock 上的 timer
+func sleep(ctx context.Context, clock Clock, delay time.Duration) error {
+	tick, stop := newTicker(clock, delay)
+	defer stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tick:
+		return nil
+	}
+}
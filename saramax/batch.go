@@ -2,21 +2,77 @@ package saramax
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/DaHuangQwQ/gpkg/logger"
 	"github.com/IBM/sarama"
-	"log"
 	"time"
 )
 
 type BatchHandler[T any] struct {
-	fn BatchHandlerFunc[T]
-	l  logger.Logger
+	fn    BatchHandlerFunc[T]
+	l     logger.Logger
+	r     *retrier
+	codec Codec
+
+	// 一批最多多少条消息
+	batchSize int
+	// 一批消息的 Value 加起来最多多少字节，0 表示不限制
+	maxBytes int
+	// 收到第一条消息之后最多等多久，凑不够一批也会处理
+	linger time.Duration
 }
 
 // NewBatchHandler kafka 批量消费
+// 默认一批最多 10 条消息，收到第一条消息之后最多等待 1s
 func NewBatchHandler[T any](l logger.Logger, fn BatchHandlerFunc[T]) *BatchHandler[T] {
-	return &BatchHandler[T]{fn: fn, l: l}
+	return &BatchHandler[T]{
+		fn:        fn,
+		l:         l.Named("saramax"),
+		r:         &retrier{now: time.Now},
+		codec:     JSONCodec{},
+		batchSize: 10,
+		linger:    time.Second,
+	}
+}
+
+// Codec 设置消息体的解码方式，默认是 JSON
+func (b *BatchHandler[T]) Codec(codec Codec) *BatchHandler[T] {
+	b.codec = codec
+	return b
+}
+
+// BatchSize 小于 1 的时候忽略，依旧使用原来的值
+func (b *BatchHandler[T]) BatchSize(size int) *BatchHandler[T] {
+	if size < 1 {
+		return b
+	}
+	b.batchSize = size
+	return b
+}
+
+// MaxBytes 达到 maxBytes 之后立刻处理，所以一批消息可能会略微超过 maxBytes
+func (b *BatchHandler[T]) MaxBytes(maxBytes int) *BatchHandler[T] {
+	b.maxBytes = maxBytes
+	return b
+}
+
+// Linger 小于等于 0 的时候忽略，依旧使用原来的值
+func (b *BatchHandler[T]) Linger(linger time.Duration) *BatchHandler[T] {
+	if linger <= 0 {
+		return b
+	}
+	b.linger = linger
+	return b
+}
+
+// Retry 设置重试策略，原地重试的时候只会重新处理失败的消息
+// 重试之后依旧失败的消息，会按照 policy 逐条转发到重试 topic 或者死信 topic
+// producer 为 nil 的时候只原地重试，重试失败之后整批消息都不提交，ConsumeClaim 返回 error
+func (b *BatchHandler[T]) Retry(producer sarama.SyncProducer, policy RetryPolicy) *BatchHandler[T] {
+	b.r.producer = producer
+	b.r.policy = policy
+	return b
 }
 
 func (b *BatchHandler[T]) Setup(session sarama.ConsumerGroupSession) error {
@@ -27,50 +83,222 @@ func (b *BatchHandler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
 	return nil
 }
 
+// ConsumeClaim 一批消息都处理成功，或者都转发到重试 topic 之后才会提交
+// 处理失败又没有转发出去的时候返回 error，整批消息都不会提交，所以处理成功的消息也可能再次被消费
+// 重新分配分区或者关闭的时候返回 nil
 func (b *BatchHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
 	msgs := claim.Messages()
-	const batchSize = 10
+	l := b.l.With(logger.String("topic", claim.Topic()),
+		logger.Int32("partition", claim.Partition()))
 	for {
-		log.Println("一个批次开始")
-		batch := make([]*sarama.ConsumerMessage, 0, batchSize)
-		ts := make([]T, 0, batchSize)
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		var done = false
-		for i := 0; i < batchSize && !done; i++ {
-			select {
-			case <-ctx.Done():
-				// 超时了
-				done = true
-			case msg, ok := <-msgs:
-				if !ok {
-					cancel()
+		batch, done := b.collect(ctx, msgs)
+		if ctx.Err() != nil {
+			// 重新分配分区了，没处理完的下次还会消费到
+			return nil
+		}
+		if len(batch) > 0 {
+			err := b.process(ctx, l, batch)
+			if err != nil {
+				if ctx.Err() != nil {
 					return nil
 				}
-				batch = append(batch, msg)
-				var t T
-				err := json.Unmarshal(msg.Value, &t)
-				if err != nil {
-					b.l.Error("反序列消息体失败",
-						logger.String("topic", msg.Topic),
-						logger.Int32("partition", msg.Partition),
-						logger.Int64("offset", msg.Offset),
-						logger.Error(err))
-					continue
-				}
-				batch = append(batch, msg)
-				ts = append(ts, t)
+				return err
+			}
+			for _, msg := range batch {
+				session.MarkMessage(msg, "")
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// collect 凑一批消息，channel 关闭的时候 done 为 true
+func (b *BatchHandler[T]) collect(ctx context.Context, msgs <-chan *sarama.ConsumerMessage) (batch []*sarama.ConsumerMessage, done bool) {
+	batch = make([]*sarama.ConsumerMessage, 0, b.batchSize)
+	size := 0
+	// 收到第一条消息之后才开始计时
+	var linger <-chan time.Time
+	for len(batch) < b.batchSize && (b.maxBytes <= 0 || size < b.maxBytes) {
+		select {
+		case <-ctx.Done():
+			return batch, true
+		case <-linger:
+			return batch, false
+		case msg, ok := <-msgs:
+			if !ok {
+				return batch, true
+			}
+			if !b.r.wait(ctx, msg) {
+				return batch, true
+			}
+			batch = append(batch, msg)
+			size += len(msg.Value)
+			if linger == nil {
+				timer := time.NewTimer(b.linger)
+				defer timer.Stop()
+				linger = timer.C
 			}
 		}
-		cancel()
-		// 凑够了一批，然后你就处理
-		err := b.fn(batch, ts)
+	}
+	return batch, false
+}
+
+func (b *BatchHandler[T]) process(ctx context.Context, l logger.Logger, batch []*sarama.ConsumerMessage) error {
+	msgs := make([]*sarama.ConsumerMessage, 0, len(batch))
+	ts := make([]T, 0, len(batch))
+	for _, msg := range batch {
+		var t T
+		err := decode(b.codec, msg, &t)
 		if err != nil {
-			b.l.Error("处理消息失败",
-				// 把真个 msgs 都记录下来
-				logger.Error(err))
+			// 重试也没有用，直接进入死信 topic
+			l.Error("反序列消息体失败", logger.Int64("offset", msg.Offset), logger.Error(err))
+			if err = b.park(l, msg, err, true); err != nil {
+				return err
+			}
+			continue
 		}
-		for _, msg := range batch {
-			session.MarkMessage(msg, "")
+		msgs = append(msgs, msg)
+		ts = append(ts, t)
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	errs := b.call(msgs, ts)
+	for _, backoff := range b.r.policy.Backoff {
+		msgs, ts, errs = failed(msgs, ts, errs)
+		if len(msgs) == 0 {
+			return nil
+		}
+		if !sleep(ctx, backoff) {
+			return ctx.Err()
+		}
+		errs = b.call(msgs, ts)
+	}
+	msgs, _, errs = failed(msgs, ts, errs)
+	if len(msgs) > 0 && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for i, msg := range msgs {
+		l.Error("处理消息失败", logger.Int64("offset", msg.Offset), logger.Error(errs[i]))
+		if err := b.park(l, msg, errs[i], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call 返回每一条消息的处理结果
+func (b *BatchHandler[T]) call(msgs []*sarama.ConsumerMessage, ts []T) []error {
+	errs := make([]error, len(msgs))
+	err := b.fn(msgs, ts)
+	if err == nil {
+		return errs
+	}
+	var be *BatchError
+	if errors.As(err, &be) && len(be.Errs) == len(msgs) {
+		copy(errs, be.Errs)
+		return errs
+	}
+	// 不是 BatchError 就认为整批都失败了
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (b *BatchHandler[T]) park(l logger.Logger, msg *sarama.ConsumerMessage, cause error, deadLetter bool) error {
+	if b.r.producer == nil {
+		// 没有地方转发，提交了这条消息就丢了
+		return fmt.Errorf("saramax: 没有设置 producer，offset %d 处理失败: %w", msg.Offset, cause)
+	}
+	var (
+		topic string
+		err   error
+	)
+	if deadLetter {
+		topic, err = b.r.deadLetter(msg, cause)
+	} else {
+		topic, err = b.r.park(msg, cause)
+	}
+	if err != nil {
+		l.Error("转发消息失败", logger.Int64("offset", msg.Offset),
+			logger.String("target", topic), logger.Error(err))
+		return err
+	}
+	l.Warn("转发消息", logger.Int64("offset", msg.Offset), logger.String("target", topic))
+	return nil
+}
+
+// failed 过滤出失败的消息
+func failed[T any](msgs []*sarama.ConsumerMessage, ts []T, errs []error) ([]*sarama.ConsumerMessage, []T, []error) {
+	var (
+		resMsgs []*sarama.ConsumerMessage
+		resTs   []T
+		resErrs []error
+	)
+	for i, err := range errs {
+		if err != nil {
+			resMsgs = append(resMsgs, msgs[i])
+			resTs = append(resTs, ts[i])
+			resErrs = append(resErrs, err)
+		}
+	}
+	return resMsgs, resTs, resErrs
+}
+
+// BatchError 一批消息部分处理失败的时候，BatchHandlerFunc 返回 BatchError
+// Errs 和消息一一对应，nil 表示处理成功，只有失败的消息会被重试
+// 返回其它 error 则认为整批消息都处理失败了
+type BatchError struct {
+	Errs []error
+}
+
+func NewBatchError(size int) *BatchError {
+	return &BatchError{Errs: make([]error, size)}
+}
+
+// Fail 标记第 i 条消息处理失败
+func (e *BatchError) Fail(i int, err error) *BatchError {
+	e.Errs[i] = err
+	return e
+}
+
+// Failed 有没有失败的消息
+func (e *BatchError) Failed() bool {
+	for _, err := range e.Errs {
+		if err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *BatchError) Error() string {
+	cnt := 0
+	var first error
+	for _, err := range e.Errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			cnt++
+		}
+	}
+	if first == nil {
+		return "saramax: 批量处理没有失败的消息"
+	}
+	return fmt.Sprintf("saramax: %d 条消息处理失败，第一个错误: %s", cnt, first)
+}
+
+func (e *BatchError) Unwrap() []error {
+	res := make([]error, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		if err != nil {
+			res = append(res, err)
 		}
 	}
+	return res
 }
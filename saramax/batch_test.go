@@ -0,0 +1,280 @@
+package saramax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/DaHuangQwQ/gpkg/logger/loggertest"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBatchHandler_ConsumeClaim(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler func(h *BatchHandler[event]) *BatchHandler[event]
+		msgs    int
+		// 每一批的 offset
+		wantBatches [][]int64
+	}{
+		{
+			name:        "按照条数分批",
+			handler:     func(h *BatchHandler[event]) *BatchHandler[event] { return h.BatchSize(10) },
+			msgs:        25,
+			wantBatches: [][]int64{offsets(0, 10), offsets(10, 20), offsets(20, 25)},
+		},
+		{
+			// 每条消息 8 个字节
+			name:        "按照字节数分批",
+			handler:     func(h *BatchHandler[event]) *BatchHandler[event] { return h.MaxBytes(20) },
+			msgs:        7,
+			wantBatches: [][]int64{offsets(0, 3), offsets(3, 6), offsets(6, 7)},
+		},
+		{
+			// 非法的参数会被忽略，依旧是默认的 10 条一批
+			name: "非法的参数",
+			handler: func(h *BatchHandler[event]) *BatchHandler[event] {
+				return h.BatchSize(0).BatchSize(-1).Linger(0).Linger(-time.Second)
+			},
+			msgs:        15,
+			wantBatches: [][]int64{offsets(0, 10), offsets(10, 15)},
+		},
+		{
+			name:    "没有消息",
+			handler: func(h *BatchHandler[event]) *BatchHandler[event] { return h },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var batches [][]int64
+			h := tc.handler(NewBatchHandler[event](loggertest.NewLogger(),
+				func(msgs []*sarama.ConsumerMessage, evts []event) error {
+					require.Len(t, evts, len(msgs))
+					var batch []int64
+					for i, msg := range msgs {
+						assert.Equal(t, msg.Offset, evts[i].ID)
+						batch = append(batch, msg.Offset)
+					}
+					batches = append(batches, batch)
+					return nil
+				}))
+			session := &mockSession{}
+			require.NoError(t, h.ConsumeClaim(session, newMockClaim("user", 0, newEvents(tc.msgs)...)))
+			assert.Equal(t, tc.wantBatches, batches)
+			var marked []int64
+			if tc.msgs > 0 {
+				marked = offsets(0, int64(tc.msgs))
+			}
+			assert.Equal(t, marked, session.offsets())
+		})
+	}
+}
+
+func TestBatchHandler_Linger(t *testing.T) {
+	ch := make(chan *sarama.ConsumerMessage)
+	claim := &mockClaim{topic: "user", msgs: ch}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &mockSession{ctx: ctx}
+	batches := make(chan []int64, 1)
+	h := NewBatchHandler[event](loggertest.NewLogger(),
+		func(msgs []*sarama.ConsumerMessage, evts []event) error {
+			var batch []int64
+			for _, msg := range msgs {
+				batch = append(batch, msg.Offset)
+			}
+			batches <- batch
+			return nil
+		}).Linger(time.Millisecond * 10)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- h.ConsumeClaim(session, claim)
+	}()
+	for _, msg := range newEvents(2) {
+		msg.Topic = "user"
+		ch <- msg
+	}
+	// 凑不够一批，等待 linger 之后处理
+	select {
+	case batch := <-batches:
+		assert.Equal(t, []int64{0, 1}, batch)
+	case <-time.After(time.Second):
+		t.Fatal("linger 之后没有处理")
+	}
+	// 重新分配分区不是错误
+	cancel()
+	assert.NoError(t, <-errCh)
+	assert.Equal(t, []int64{0, 1}, session.offsets())
+}
+
+func TestBatchHandler_NoProducer(t *testing.T) {
+	bizErr := errors.New("mock error")
+	h := NewBatchHandler[event](loggertest.NewLogger(),
+		func(msgs []*sarama.ConsumerMessage, evts []event) error {
+			return NewBatchError(len(msgs)).Fail(1, bizErr)
+		})
+	session := &mockSession{}
+	// 没有设置 producer，失败的消息没有地方转发，整批都不能提交
+	err := h.ConsumeClaim(session, newMockClaim("user", 0, newEvents(3)...))
+	assert.EqualError(t, err, "saramax: 没有设置 producer，offset 1 处理失败: mock error")
+	assert.ErrorIs(t, err, bizErr)
+	assert.Empty(t, session.offsets())
+
+	// 处理的过程中重新分配分区了，不是错误
+	ctx, cancel := context.WithCancel(context.Background())
+	h = NewBatchHandler[event](loggertest.NewLogger(),
+		func(msgs []*sarama.ConsumerMessage, evts []event) error {
+			cancel()
+			return bizErr
+		}).Retry(nil, RetryPolicy{Backoff: []time.Duration{time.Minute}})
+	session = &mockSession{ctx: ctx}
+	assert.NoError(t, h.ConsumeClaim(session, newMockClaim("user", 0, newEvents(3)...)))
+	assert.Empty(t, session.offsets())
+}
+
+func TestBatchHandler_Retry(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	policy := RetryPolicy{
+		Backoff: []time.Duration{time.Millisecond},
+		Tiers:   []time.Duration{time.Second * 5},
+	}
+	bizErr := errors.New("mock error")
+	testCases := []struct {
+		name string
+		msgs []*sarama.ConsumerMessage
+		fn   func(call int, msgs []*sarama.ConsumerMessage) error
+		// 期望转发的消息
+		sent    []string
+		sendErr error
+
+		wantErr    error
+		wantCalls  [][]int64
+		wantMarked []int64
+	}{
+		{
+			name: "部分失败，只重试失败的消息",
+			msgs: newEvents(4),
+			fn: func(call int, msgs []*sarama.ConsumerMessage) error {
+				if call == 0 {
+					return NewBatchError(len(msgs)).Fail(1, bizErr).Fail(3, bizErr)
+				}
+				// 重试的时候 offset 1 成功了，offset 3 依旧失败
+				return NewBatchError(len(msgs)).Fail(1, bizErr)
+			},
+			sent:       []string{"user.retry.5s:3"},
+			wantCalls:  [][]int64{{0, 1, 2, 3}, {1, 3}},
+			wantMarked: []int64{0, 1, 2, 3},
+		},
+		{
+			name: "整批失败",
+			msgs: newEvents(2),
+			fn: func(call int, msgs []*sarama.ConsumerMessage) error {
+				return bizErr
+			},
+			sent:       []string{"user.retry.5s:0", "user.retry.5s:1"},
+			wantCalls:  [][]int64{{0, 1}, {0, 1}},
+			wantMarked: []int64{0, 1},
+		},
+		{
+			name: "BatchError 没有失败的消息",
+			msgs: newEvents(2),
+			fn: func(call int, msgs []*sarama.ConsumerMessage) error {
+				return NewBatchError(len(msgs))
+			},
+			wantCalls:  [][]int64{{0, 1}},
+			wantMarked: []int64{0, 1},
+		},
+		{
+			name: "反序列化失败直接进入死信 topic",
+			msgs: append(newEvents(2), &sarama.ConsumerMessage{Offset: 2, Value: []byte(`{"id":`)}),
+			fn: func(call int, msgs []*sarama.ConsumerMessage) error {
+				return nil
+			},
+			sent:       []string{"user.dlq:2"},
+			wantCalls:  [][]int64{{0, 1}},
+			wantMarked: []int64{0, 1, 2},
+		},
+		{
+			name: "转发失败，整批不提交",
+			msgs: newEvents(2),
+			fn: func(call int, msgs []*sarama.ConsumerMessage) error {
+				return NewBatchError(len(msgs)).Fail(0, bizErr)
+			},
+			sent:      []string{"user.retry.5s:0"},
+			sendErr:   sarama.ErrOutOfBrokers,
+			wantErr:   sarama.ErrOutOfBrokers,
+			wantCalls: [][]int64{{0, 1}, {0}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sent []string
+			producer := mocks.NewSyncProducer(t, nil)
+			for range tc.sent {
+				checker := func(msg *sarama.ProducerMessage) error {
+					for _, h := range msg.Headers {
+						if string(h.Key) == HeaderOriginalOffset {
+							sent = append(sent, fmt.Sprintf("%s:%s", msg.Topic, h.Value))
+						}
+					}
+					return nil
+				}
+				if tc.sendErr != nil {
+					producer.ExpectSendMessageWithMessageCheckerFunctionAndFail(checker, tc.sendErr)
+				} else {
+					producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(checker)
+				}
+			}
+			var calls [][]int64
+			l := loggertest.NewLogger()
+			h := NewBatchHandler[event](l, func(msgs []*sarama.ConsumerMessage, evts []event) error {
+				var call []int64
+				for _, msg := range msgs {
+					call = append(call, msg.Offset)
+				}
+				calls = append(calls, call)
+				return tc.fn(len(calls)-1, msgs)
+			}).Retry(producer, policy)
+			h.r.now = func() time.Time {
+				return now
+			}
+			session := &mockSession{}
+
+			err := h.ConsumeClaim(session, newMockClaim("user", 0, tc.msgs...))
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantCalls, calls)
+			assert.Equal(t, tc.wantMarked, session.offsets())
+			assert.Equal(t, tc.sent, sent)
+			require.NoError(t, producer.Close())
+			if len(tc.sent) > 0 && tc.sendErr == nil {
+				l.AssertLogged(t, logger.WarnLevel, "转发消息", logger.String("topic", "user"))
+			}
+		})
+	}
+}
+
+// newEvents 消息的 offset 和 id 一样，offset 小于 10 的时候 Value 是 8 个字节
+func newEvents(n int) []*sarama.ConsumerMessage {
+	res := make([]*sarama.ConsumerMessage, 0, n)
+	for i := 0; i < n; i++ {
+		res = append(res, &sarama.ConsumerMessage{
+			Offset: int64(i),
+			Value:  []byte(fmt.Sprintf(`{"id":%d}`, i)),
+		})
+	}
+	return res
+}
+
+func offsets(start, end int64) []int64 {
+	res := make([]int64, 0, end-start)
+	for i := start; i < end; i++ {
+		res = append(res, i)
+	}
+	return res
+}
@@ -0,0 +1,79 @@
+package saramax
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/IBM/sarama"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+)
+
+// Codec 消息体的编解码，Handler、BatchHandler 和 Producer 共用
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// HeaderCodec 编解码的时候需要读写消息头的 Codec，比如把 schema ID 放在消息头里面
+// Handler、BatchHandler 和 Producer 会优先使用这两个方法
+type HeaderCodec interface {
+	Codec
+	MarshalWithHeaders(v any) ([]byte, []sarama.RecordHeader, error)
+	UnmarshalWithHeaders(data []byte, headers []*sarama.RecordHeader, v any) error
+}
+
+// JSONCodec 默认的编解码方式
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// ProtoCodec protobuf 编解码
+// T 一般是生成代码里面的指针类型，比如 Handler[*pb.User]，解码的时候会自动创建消息
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("saramax: %T 不是 proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	// v 是 *T，T 是 proto.Message 的指针
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() && rv.Elem().Kind() == reflect.Pointer {
+		elem := rv.Elem()
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		if m, ok := elem.Interface().(proto.Message); ok {
+			return proto.Unmarshal(data, m)
+		}
+	}
+	return fmt.Errorf("saramax: %T 不是 proto.Message", v)
+}
+
+func encode(codec Codec, v any) ([]byte, []sarama.RecordHeader, error) {
+	if hc, ok := codec.(HeaderCodec); ok {
+		return hc.MarshalWithHeaders(v)
+	}
+	data, err := codec.Marshal(v)
+	return data, nil, err
+}
+
+func decode(codec Codec, msg *sarama.ConsumerMessage, v any) error {
+	if hc, ok := codec.(HeaderCodec); ok {
+		return hc.UnmarshalWithHeaders(msg.Value, msg.Headers, v)
+	}
+	return codec.Unmarshal(msg.Value, v)
+}
@@ -0,0 +1,47 @@
+package saramax
+
+import (
+	"github.com/DaHuangQwQ/gpkg/logger/loggertest"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"testing"
+)
+
+func TestProtoCodec(t *testing.T) {
+	codec := ProtoCodec{}
+	data, err := codec.Marshal(wrapperspb.String("hello"))
+	require.NoError(t, err)
+
+	// 生成代码的指针类型，解码的时候创建消息
+	var ptr *wrapperspb.StringValue
+	require.NoError(t, codec.Unmarshal(data, &ptr))
+	assert.Equal(t, "hello", ptr.GetValue())
+
+	var val wrapperspb.StringValue
+	require.NoError(t, codec.Unmarshal(data, &val))
+	assert.Equal(t, "hello", val.GetValue())
+
+	_, err = codec.Marshal(event{ID: 1})
+	assert.EqualError(t, err, "saramax: saramax.event 不是 proto.Message")
+	var evt event
+	assert.EqualError(t, codec.Unmarshal(data, &evt), "saramax: *saramax.event 不是 proto.Message")
+}
+
+func TestHandler_Codec(t *testing.T) {
+	data, err := proto.Marshal(wrapperspb.String("hello"))
+	require.NoError(t, err)
+	var handled []string
+	h := NewHandler[*wrapperspb.StringValue](loggertest.NewLogger(),
+		func(msg *sarama.ConsumerMessage, evt *wrapperspb.StringValue) error {
+			handled = append(handled, evt.GetValue())
+			return nil
+		}).Codec(ProtoCodec{})
+	session := &mockSession{}
+	require.NoError(t, h.ConsumeClaim(session, newMockClaim("user", 0,
+		&sarama.ConsumerMessage{Offset: 1, Value: data})))
+	assert.Equal(t, []string{"hello"}, handled)
+	assert.Equal(t, []int64{1}, session.offsets())
+}
@@ -1,18 +1,36 @@
 package saramax
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"github.com/DaHuangQwQ/gpkg/logger"
 	"github.com/IBM/sarama"
+	"time"
 )
 
 type Handler[T any] struct {
-	fn HandlerFunc[T]
-	l  logger.Logger
+	fn    HandlerFunc[T]
+	l     logger.Logger
+	r     *retrier
+	codec Codec
 }
 
 func NewHandler[T any](l logger.Logger, fn HandlerFunc[T]) *Handler[T] {
-	return &Handler[T]{l: l, fn: fn}
+	return &Handler[T]{l: l.Named("saramax"), fn: fn, r: &retrier{now: time.Now}, codec: JSONCodec{}}
+}
+
+// Codec 设置消息体的解码方式，默认是 JSON
+func (h *Handler[T]) Codec(codec Codec) *Handler[T] {
+	h.codec = codec
+	return h
+}
+
+// Retry 设置重试策略，处理失败的消息会按照 policy 转发到重试 topic 或者死信 topic
+// producer 为 nil 的时候只原地重试，重试失败之后不提交这条消息，ConsumeClaim 返回 error
+func (h *Handler[T]) Retry(producer sarama.SyncProducer, policy RetryPolicy) *Handler[T] {
+	h.r.producer = producer
+	h.r.policy = policy
+	return h
 }
 
 func (h *Handler[T]) Setup(session sarama.ConsumerGroupSession) error {
@@ -23,29 +41,71 @@ func (h *Handler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
 	return nil
 }
 
+// ConsumeClaim 处理失败又没有转发出去的时候返回 error，并且不会提交这条消息，避免消息丢失
+// 重新分配分区或者关闭的时候返回 nil，没提交的消息下次还会消费到
 func (h *Handler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	msgs := claim.Messages()
+	l := h.l.With(logger.String("topic", claim.Topic()),
+		logger.Int32("partition", claim.Partition()))
+	ctx := session.Context()
 	for msg := range msgs {
-		// 在这里调用业务处理逻辑
-		var t T
-		err := json.Unmarshal(msg.Value, &t)
+		err := h.consume(ctx, l, msg)
 		if err != nil {
-			// 你也可以在这里引入重试的逻辑
-			h.l.Error("反序列消息体失败",
-				logger.String("topic", msg.Topic),
-				logger.Int32("partition", msg.Partition),
-				logger.Int64("offset", msg.Offset),
-				logger.Error(err))
-		}
-		err = h.fn(msg, t)
-		if err != nil {
-			h.l.Error("处理消息失败",
-				logger.String("topic", msg.Topic),
-				logger.Int32("partition", msg.Partition),
-				logger.Int64("offset", msg.Offset),
-				logger.Error(err))
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
 		}
 		session.MarkMessage(msg, "")
 	}
 	return nil
 }
+
+func (h *Handler[T]) consume(ctx context.Context, l logger.Logger, msg *sarama.ConsumerMessage) error {
+	if !h.r.wait(ctx, msg) {
+		return ctx.Err()
+	}
+	var t T
+	err := decode(h.codec, msg, &t)
+	if err != nil {
+		// 重试也没有用，直接进入死信 topic
+		l.Error("反序列消息体失败", logger.Int64("offset", msg.Offset), logger.Error(err))
+		return h.park(l, msg, err, true)
+	}
+	// 在这里调用业务处理逻辑
+	err = h.r.do(ctx, func() error {
+		return h.fn(msg, t)
+	})
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		// 重新分配分区了，不提交，下次还会消费到
+		return ctx.Err()
+	}
+	l.Error("处理消息失败", logger.Int64("offset", msg.Offset), logger.Error(err))
+	return h.park(l, msg, err, false)
+}
+
+func (h *Handler[T]) park(l logger.Logger, msg *sarama.ConsumerMessage, cause error, deadLetter bool) error {
+	if h.r.producer == nil {
+		// 没有地方转发，提交了这条消息就丢了
+		return fmt.Errorf("saramax: 没有设置 producer，offset %d 处理失败: %w", msg.Offset, cause)
+	}
+	var (
+		topic string
+		err   error
+	)
+	if deadLetter {
+		topic, err = h.r.deadLetter(msg, cause)
+	} else {
+		topic, err = h.r.park(msg, cause)
+	}
+	if err != nil {
+		l.Error("转发消息失败", logger.Int64("offset", msg.Offset),
+			logger.String("target", topic), logger.Error(err))
+		return err
+	}
+	l.Warn("转发消息", logger.Int64("offset", msg.Offset), logger.String("target", topic))
+	return nil
+}
@@ -0,0 +1,344 @@
+package saramax
+
+import (
+	"context"
+	"errors"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/DaHuangQwQ/gpkg/logger/loggertest"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type event struct {
+	ID int64 `json:"id"`
+}
+
+func TestHandler_ConsumeClaim(t *testing.T) {
+	l := loggertest.NewLogger()
+	var handled []int64
+	h := NewHandler[event](l, func(msg *sarama.ConsumerMessage, evt event) error {
+		handled = append(handled, msg.Offset)
+		if evt.ID == 2 {
+			return errors.New("mock error")
+		}
+		return nil
+	})
+	claim := newMockClaim("user", 1,
+		&sarama.ConsumerMessage{Offset: 10, Value: []byte(`{"id": 1}`)},
+		&sarama.ConsumerMessage{Offset: 11, Value: []byte(`{"id": 2}`)},
+		&sarama.ConsumerMessage{Offset: 12, Value: []byte(`{"id": 1}`)},
+	)
+	session := &mockSession{}
+	// 没有设置 producer，处理失败的消息不能提交，也不能继续消费后面的消息
+	err := h.ConsumeClaim(session, claim)
+	assert.EqualError(t, err, "saramax: 没有设置 producer，offset 11 处理失败: mock error")
+	assert.Equal(t, []int64{10, 11}, handled)
+	assert.Equal(t, []int64{10}, session.offsets())
+	l.AssertLogged(t, logger.ErrorLevel, "处理消息失败",
+		logger.String("topic", "user"),
+		logger.Int32("partition", 1),
+		logger.Int64("offset", 11))
+	entries := l.FilterMessage("处理消息失败")
+	require.Len(t, entries, 1)
+	assert.Equal(t, "saramax", entries[0].Name)
+	errVal, _ := entries[0].Value("error")
+	assert.EqualError(t, errVal.(error), "mock error")
+
+	// 反序列化失败不会调用业务逻辑
+	handled = nil
+	session = &mockSession{}
+	err = h.ConsumeClaim(session, newMockClaim("user", 1,
+		&sarama.ConsumerMessage{Offset: 20, Value: []byte(`{"id":`)},
+		&sarama.ConsumerMessage{Offset: 21, Value: []byte(`{"id": 1}`)},
+	))
+	assert.Error(t, err)
+	assert.Empty(t, handled)
+	assert.Empty(t, session.offsets())
+	l.AssertLogged(t, logger.ErrorLevel, "反序列消息体失败",
+		logger.String("topic", "user"),
+		logger.Int64("offset", 20))
+}
+
+func TestHandler_ConsumeClaimCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewHandler[event](loggertest.NewLogger(), func(msg *sarama.ConsumerMessage, evt event) error {
+		// 处理的过程中重新分配分区了
+		cancel()
+		return errors.New("mock error")
+	}).Retry(nil, RetryPolicy{Backoff: []time.Duration{time.Minute}})
+	session := &mockSession{ctx: ctx}
+	// 重新分配分区不是错误，没提交的消息下次还会消费到
+	err := h.ConsumeClaim(session, newMockClaim("user", 1,
+		&sarama.ConsumerMessage{Offset: 10, Value: []byte(`{"id": 1}`)}))
+	assert.NoError(t, err)
+	assert.Empty(t, session.offsets())
+}
+
+func TestHandler_Retry(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	policy := RetryPolicy{
+		Backoff: []time.Duration{time.Millisecond, time.Millisecond},
+		Tiers:   []time.Duration{time.Second * 5, time.Minute},
+	}
+	bizErr := errors.New("mock error")
+	testCases := []struct {
+		name string
+		// 失败几次之后成功，-1 是一直失败
+		failures int
+		msg      *sarama.ConsumerMessage
+		topic    string
+		ctx      context.Context
+		sendErr  error
+
+		wantErr     error
+		wantCalls   int
+		wantMarked  []int64
+		wantTopic   string
+		wantHeaders map[string]string
+	}{
+		{
+			name:       "原地重试成功",
+			failures:   2,
+			topic:      "user",
+			msg:        &sarama.ConsumerMessage{Offset: 10, Value: []byte(`{"id": 1}`)},
+			wantCalls:  3,
+			wantMarked: []int64{10},
+		},
+		{
+			name:       "进入第一个重试 topic",
+			failures:   -1,
+			topic:      "user",
+			msg:        &sarama.ConsumerMessage{Offset: 10, Key: []byte("1"), Value: []byte(`{"id": 1}`)},
+			wantCalls:  3,
+			wantMarked: []int64{10},
+			wantTopic:  "user.retry.5s",
+			wantHeaders: map[string]string{
+				HeaderRetryAttempt:      "1",
+				HeaderRetryAt:           "1700000005000",
+				HeaderOriginalTopic:     "user",
+				HeaderOriginalPartition: "1",
+				HeaderOriginalOffset:    "10",
+				HeaderError:             "mock error",
+				HeaderFailedAt:          now.Format(time.RFC3339),
+			},
+		},
+		{
+			name:     "进入下一个重试 topic",
+			failures: -1,
+			topic:    "user.retry.5s",
+			msg: &sarama.ConsumerMessage{Offset: 3, Value: []byte(`{"id": 1}`),
+				Headers: []*sarama.RecordHeader{
+					{Key: []byte(HeaderRetryAttempt), Value: []byte("1")},
+					{Key: []byte(HeaderRetryAt), Value: []byte("1699999999000")},
+					{Key: []byte(HeaderOriginalTopic), Value: []byte("user")},
+					{Key: []byte(HeaderOriginalPartition), Value: []byte("2")},
+					{Key: []byte(HeaderOriginalOffset), Value: []byte("10")},
+					{Key: []byte(HeaderError), Value: []byte("old error")},
+				}},
+			wantCalls:  3,
+			wantMarked: []int64{3},
+			wantTopic:  "user.retry.1m",
+			wantHeaders: map[string]string{
+				HeaderRetryAttempt:      "2",
+				HeaderRetryAt:           "1700000060000",
+				HeaderOriginalTopic:     "user",
+				HeaderOriginalPartition: "2",
+				HeaderOriginalOffset:    "10",
+				HeaderError:             "mock error",
+				HeaderFailedAt:          now.Format(time.RFC3339),
+			},
+		},
+		{
+			name:     "进入死信 topic",
+			failures: -1,
+			topic:    "user.retry.1m",
+			msg: &sarama.ConsumerMessage{Offset: 4, Value: []byte(`{"id": 1}`),
+				Headers: []*sarama.RecordHeader{
+					{Key: []byte("trace-id"), Value: []byte("abc")},
+					{Key: []byte(HeaderRetryAttempt), Value: []byte("2")},
+					{Key: []byte(HeaderOriginalTopic), Value: []byte("user")},
+					{Key: []byte(HeaderOriginalPartition), Value: []byte("2")},
+					{Key: []byte(HeaderOriginalOffset), Value: []byte("10")},
+				}},
+			wantCalls:  3,
+			wantMarked: []int64{4},
+			wantTopic:  "user.dlq",
+			wantHeaders: map[string]string{
+				"trace-id":              "abc",
+				HeaderRetryAttempt:      "2",
+				HeaderOriginalTopic:     "user",
+				HeaderOriginalPartition: "2",
+				HeaderOriginalOffset:    "10",
+				HeaderError:             "mock error",
+				HeaderFailedAt:          now.Format(time.RFC3339),
+			},
+		},
+		{
+			name:       "反序列化失败直接进入死信 topic",
+			topic:      "user",
+			msg:        &sarama.ConsumerMessage{Offset: 10, Value: []byte(`{"id":`)},
+			wantMarked: []int64{10},
+			wantTopic:  "user.dlq",
+			wantHeaders: map[string]string{
+				HeaderOriginalTopic:     "user",
+				HeaderOriginalPartition: "1",
+				HeaderOriginalOffset:    "10",
+				HeaderError:             "unexpected end of JSON input",
+				HeaderFailedAt:          now.Format(time.RFC3339),
+			},
+		},
+		{
+			name:      "转发失败，不提交",
+			failures:  -1,
+			topic:     "user",
+			msg:       &sarama.ConsumerMessage{Offset: 10, Value: []byte(`{"id": 1}`)},
+			sendErr:   sarama.ErrNotLeaderForPartition,
+			wantErr:   sarama.ErrNotLeaderForPartition,
+			wantCalls: 3,
+			wantTopic: "user.retry.5s",
+		},
+		{
+			name:     "等待重试的时候重新分配分区，不提交",
+			failures: -1,
+			topic:    "user.retry.5s",
+			msg: &sarama.ConsumerMessage{Offset: 3, Value: []byte(`{"id": 1}`),
+				Headers: []*sarama.RecordHeader{
+					{Key: []byte(HeaderRetryAt), Value: []byte("1700000005000")},
+				}},
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			}(),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sent []*sarama.ProducerMessage
+			producer := mocks.NewSyncProducer(t, nil)
+			if tc.wantTopic != "" {
+				checker := func(msg *sarama.ProducerMessage) error {
+					sent = append(sent, msg)
+					return nil
+				}
+				if tc.sendErr != nil {
+					producer.ExpectSendMessageWithMessageCheckerFunctionAndFail(checker, tc.sendErr)
+				} else {
+					producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(checker)
+				}
+			}
+			calls := 0
+			h := NewHandler[event](loggertest.NewLogger(), func(msg *sarama.ConsumerMessage, evt event) error {
+				calls++
+				if tc.failures < 0 || calls <= tc.failures {
+					return bizErr
+				}
+				return nil
+			}).Retry(producer, policy)
+			h.r.now = func() time.Time {
+				return now
+			}
+			session := &mockSession{ctx: tc.ctx}
+
+			err := h.ConsumeClaim(session, newMockClaim(tc.topic, 1, tc.msg))
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantCalls, calls)
+			assert.Equal(t, tc.wantMarked, session.offsets())
+			require.NoError(t, producer.Close())
+			if tc.wantTopic == "" {
+				return
+			}
+			require.Len(t, sent, 1)
+			assert.Equal(t, tc.wantTopic, sent[0].Topic)
+			val, _ := sent[0].Value.Encode()
+			assert.Equal(t, tc.msg.Value, val)
+			if tc.msg.Key != nil {
+				key, _ := sent[0].Key.Encode()
+				assert.Equal(t, tc.msg.Key, key)
+			}
+			if tc.sendErr != nil {
+				return
+			}
+			headers := make(map[string]string, len(sent[0].Headers))
+			for _, h := range sent[0].Headers {
+				headers[string(h.Key)] = string(h.Value)
+			}
+			assert.Equal(t, tc.wantHeaders, headers)
+		})
+	}
+}
+
+func TestRetryPolicy_RetryTopics(t *testing.T) {
+	policy := RetryPolicy{
+		Tiers: []time.Duration{time.Millisecond * 500, time.Second * 5, time.Second * 90, time.Minute, time.Hour * 2},
+	}
+	assert.Equal(t, []string{
+		"user.retry.500ms",
+		"user.retry.5s",
+		"user.retry.90s",
+		"user.retry.1m",
+		"user.retry.2h",
+	}, policy.RetryTopics("user"))
+}
+
+// mockClaim 只实现了 ConsumeClaim 用到的方法
+type mockClaim struct {
+	sarama.ConsumerGroupClaim
+	topic     string
+	partition int32
+	msgs      chan *sarama.ConsumerMessage
+}
+
+// newMockClaim 消息会被补上 topic 和 partition，channel 会被关闭
+func newMockClaim(topic string, partition int32, msgs ...*sarama.ConsumerMessage) *mockClaim {
+	ch := make(chan *sarama.ConsumerMessage, len(msgs))
+	for _, msg := range msgs {
+		msg.Topic = topic
+		msg.Partition = partition
+		ch <- msg
+	}
+	close(ch)
+	return &mockClaim{topic: topic, partition: partition, msgs: ch}
+}
+
+func (c *mockClaim) Topic() string {
+	return c.topic
+}
+
+func (c *mockClaim) Partition() int32 {
+	return c.partition
+}
+
+func (c *mockClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.msgs
+}
+
+// mockSession 记录提交过的消息
+type mockSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []*sarama.ConsumerMessage
+}
+
+func (s *mockSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg)
+}
+
+func (s *mockSession) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *mockSession) offsets() []int64 {
+	var res []int64
+	for _, msg := range s.marked {
+		res = append(res, msg.Offset)
+	}
+	return res
+}
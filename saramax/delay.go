@@ -0,0 +1,247 @@
+package saramax
+
+import (
+	"context"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/IBM/sarama"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 延时消息用 header 记录投递的信息
+const (
+	// HeaderDelayTarget 最终投递的 topic
+	HeaderDelayTarget = "x-delay-target"
+	// HeaderDelayDeliverAt 最终投递的时间，unix 毫秒
+	HeaderDelayDeliverAt = "x-delay-deliver-at"
+	// HeaderDelayDueAt 在当前延时 topic 里面要等到什么时候，unix 毫秒
+	HeaderDelayDueAt = "x-delay-due-at"
+)
+
+// DefaultDelayLevels 默认的延时等级，每个等级对应一个延时 topic，例如 delay.5s
+var DefaultDelayLevels = []time.Duration{
+	time.Second, time.Second * 5, time.Second * 10, time.Second * 30,
+	time.Minute, time.Minute * 5, time.Minute * 10, time.Minute * 30,
+	time.Hour, time.Hour * 2,
+}
+
+// DelayTopic 例如 delay.5s、delay.1m
+func DelayTopic(level time.Duration) string {
+	return "delay." + formatDelay(level)
+}
+
+// DelayTopics DelayForwarder 需要订阅的所有延时 topic
+func DelayTopics(levels []time.Duration) []string {
+	res := make([]string, 0, len(levels))
+	for _, level := range levels {
+		res = append(res, DelayTopic(level))
+	}
+	return res
+}
+
+// delayLevels 选择延时 topic
+type delayLevels []time.Duration
+
+func newDelayLevels(levels []time.Duration) delayLevels {
+	res := make(delayLevels, len(levels))
+	copy(res, levels)
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// pick 选择不超过 remaining 的最大的等级，没有的话返回 false，也就是可以直接投递了
+// 每一跳最多等待一个等级的时间，剩下的时间再进入更小的等级
+func (d delayLevels) pick(remaining time.Duration) (time.Duration, bool) {
+	idx := sort.Search(len(d), func(i int) bool {
+		return d[i] > remaining
+	})
+	if idx == 0 {
+		return 0, false
+	}
+	return d[idx-1], true
+}
+
+// DelayProducer 发送延时消息
+// 消息先进入延时 topic，由 DelayForwarder 在到期之后转发到 ProducerMessage.Topic
+type DelayProducer struct {
+	producer sarama.SyncProducer
+	levels   delayLevels
+	now      func() time.Time
+}
+
+// NewDelayProducer levels 为空的时候使用 DefaultDelayLevels，要和 DelayForwarder 保持一致
+func NewDelayProducer(producer sarama.SyncProducer, levels ...time.Duration) *DelayProducer {
+	if len(levels) == 0 {
+		levels = DefaultDelayLevels
+	}
+	return &DelayProducer{producer: producer, levels: newDelayLevels(levels), now: time.Now}
+}
+
+// SendMessage 在 deliverAt 之后投递到 msg.Topic
+// 距离 deliverAt 不足最小的等级的时候直接投递，所以精度就是最小的等级
+func (p *DelayProducer) SendMessage(msg *sarama.ProducerMessage, deliverAt time.Time) error {
+	now := p.now()
+	level, ok := p.levels.pick(deliverAt.Sub(now))
+	if !ok {
+		_, _, err := p.producer.SendMessage(msg)
+		return err
+	}
+	delayed := *msg
+	delayed.Topic = DelayTopic(level)
+	delayed.Headers = append(withoutDelayHeaders(msg.Headers),
+		recordHeader(HeaderDelayTarget, msg.Topic),
+		recordHeader(HeaderDelayDeliverAt, strconv.FormatInt(deliverAt.UnixMilli(), 10)),
+		recordHeader(HeaderDelayDueAt, strconv.FormatInt(now.Add(level).UnixMilli(), 10)))
+	_, _, err := p.producer.SendMessage(&delayed)
+	return err
+}
+
+// Pauser 暂停和恢复拉取消息，sarama.ConsumerGroup 实现了这个接口
+type Pauser interface {
+	Pause(partitions map[string][]int32)
+	Resume(partitions map[string][]int32)
+}
+
+// DelayForwarder 消费延时 topic，到期之后转发到下一个延时 topic 或者目标 topic
+//
+// 同一个延时 topic 里面的消息等待的时间都一样，所以先进入的一定先到期，
+// 只需要等待队头的消息到期就可以，等待的时候会暂停这个分区，避免拉取太多消息。
+//
+// 顺序保证：
+//   - 消息的 key 保持不变，同一个 key 在同一个延时 topic 里面是有序的
+//   - 不同等级的延时 topic 之间没有顺序保证，deliverAt 相同的消息也可能乱序投递
+//   - 不会提前投递，但是会因为转发和 rebalance 延后投递
+//   - 转发成功之后才会提交，所以可能重复投递
+type DelayForwarder struct {
+	producer sarama.SyncProducer
+	// 为 nil 的时候等待期间不暂停分区
+	pauser Pauser
+	levels delayLevels
+	l      logger.Logger
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) bool
+}
+
+// NewDelayForwarder levels 为空的时候使用 DefaultDelayLevels，要订阅 DelayTopics(levels)
+func NewDelayForwarder(producer sarama.SyncProducer, pauser Pauser, l logger.Logger, levels ...time.Duration) *DelayForwarder {
+	if len(levels) == 0 {
+		levels = DefaultDelayLevels
+	}
+	return &DelayForwarder{
+		producer: producer,
+		pauser:   pauser,
+		levels:   newDelayLevels(levels),
+		l:        l.Named("saramax.delay"),
+		now:      time.Now,
+		sleep:    sleep,
+	}
+}
+
+func (f *DelayForwarder) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (f *DelayForwarder) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (f *DelayForwarder) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	partitions := map[string][]int32{claim.Topic(): {claim.Partition()}}
+	for msg := range claim.Messages() {
+		if !f.waitDue(ctx, msg, partitions) {
+			return ctx.Err()
+		}
+		err := f.forward(ctx, msg)
+		if err != nil {
+			f.l.Error("转发延时消息失败",
+				logger.String("topic", msg.Topic),
+				logger.Int32("partition", msg.Partition),
+				logger.Int64("offset", msg.Offset),
+				logger.Error(err))
+			return err
+		}
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+// waitDue 等待队头的消息到期，等待期间暂停分区
+func (f *DelayForwarder) waitDue(ctx context.Context, msg *sarama.ConsumerMessage, partitions map[string][]int32) bool {
+	d := headerTime(msg, HeaderDelayDueAt).Sub(f.now())
+	if d <= 0 {
+		return true
+	}
+	if f.pauser != nil {
+		f.pauser.Pause(partitions)
+		defer f.pauser.Resume(partitions)
+	}
+	return f.sleep(ctx, d)
+}
+
+func (f *DelayForwarder) forward(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	target, ok := header(msg, HeaderDelayTarget)
+	if !ok {
+		f.l.Error("延时消息缺少目标 topic，丢弃",
+			logger.String("topic", msg.Topic),
+			logger.Int64("offset", msg.Offset))
+		return nil
+	}
+	deliverAt := headerTime(msg, HeaderDelayDeliverAt)
+	now := f.now()
+	pm := &sarama.ProducerMessage{
+		Topic: target,
+		Value: sarama.ByteEncoder(msg.Value),
+	}
+	if msg.Key != nil {
+		pm.Key = sarama.ByteEncoder(msg.Key)
+	}
+	level, ok := f.levels.pick(deliverAt.Sub(now))
+	if ok {
+		// 还没到期，进入下一个延时 topic
+		pm.Topic = DelayTopic(level)
+		pm.Headers = append(withoutDelayHeaders(consumerHeaders(msg)),
+			recordHeader(HeaderDelayTarget, target),
+			recordHeader(HeaderDelayDeliverAt, strconv.FormatInt(deliverAt.UnixMilli(), 10)),
+			recordHeader(HeaderDelayDueAt, strconv.FormatInt(now.Add(level).UnixMilli(), 10)))
+	} else {
+		// 剩下的时间不足最小的等级，原地等待
+		if remaining := deliverAt.Sub(now); remaining > 0 && !f.sleep(ctx, remaining) {
+			return ctx.Err()
+		}
+		pm.Headers = withoutDelayHeaders(consumerHeaders(msg))
+	}
+	_, _, err := f.producer.SendMessage(pm)
+	return err
+}
+
+func headerTime(msg *sarama.ConsumerMessage, key string) time.Time {
+	val, _ := header(msg, key)
+	ms, _ := strconv.ParseInt(val, 10, 64)
+	return time.UnixMilli(ms)
+}
+
+func withoutDelayHeaders(headers []sarama.RecordHeader) []sarama.RecordHeader {
+	res := make([]sarama.RecordHeader, 0, len(headers)+3)
+	for _, h := range headers {
+		switch string(h.Key) {
+		case HeaderDelayTarget, HeaderDelayDeliverAt, HeaderDelayDueAt:
+		default:
+			res = append(res, h)
+		}
+	}
+	return res
+}
+
+func consumerHeaders(msg *sarama.ConsumerMessage) []sarama.RecordHeader {
+	res := make([]sarama.RecordHeader, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		if h != nil {
+			res = append(res, *h)
+		}
+	}
+	return res
+}
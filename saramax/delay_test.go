@@ -0,0 +1,243 @@
+package saramax
+
+import (
+	"context"
+	"github.com/DaHuangQwQ/gpkg/logger/loggertest"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDelayLevels_pick(t *testing.T) {
+	levels := newDelayLevels([]time.Duration{time.Minute, time.Second, time.Second * 5})
+	testCases := []struct {
+		remaining time.Duration
+		want      time.Duration
+		wantOk    bool
+	}{
+		{remaining: -time.Second},
+		{remaining: time.Millisecond * 999},
+		{remaining: time.Second, want: time.Second, wantOk: true},
+		{remaining: time.Second * 7, want: time.Second * 5, wantOk: true},
+		{remaining: time.Hour, want: time.Minute, wantOk: true},
+	}
+	for _, tc := range testCases {
+		level, ok := levels.pick(tc.remaining)
+		assert.Equal(t, tc.wantOk, ok, tc.remaining)
+		assert.Equal(t, tc.want, level, tc.remaining)
+	}
+}
+
+func TestDelayProducer_SendMessage(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	testCases := []struct {
+		name        string
+		deliverAt   time.Time
+		wantTopic   string
+		wantHeaders map[string]string
+	}{
+		{
+			name:        "不足最小等级直接投递",
+			deliverAt:   now.Add(time.Millisecond * 500),
+			wantTopic:   "order",
+			wantHeaders: map[string]string{"trace-id": "abc"},
+		},
+		{
+			name:      "进入延时 topic",
+			deliverAt: now.Add(time.Second * 7),
+			wantTopic: "delay.5s",
+			wantHeaders: map[string]string{
+				"trace-id":           "abc",
+				HeaderDelayTarget:    "order",
+				HeaderDelayDeliverAt: "1700000007000",
+				HeaderDelayDueAt:     "1700000005000",
+			},
+		},
+		{
+			name:      "超过最大等级",
+			deliverAt: now.Add(time.Hour * 3),
+			wantTopic: "delay.2h",
+			wantHeaders: map[string]string{
+				"trace-id":           "abc",
+				HeaderDelayTarget:    "order",
+				HeaderDelayDeliverAt: "1700010800000",
+				HeaderDelayDueAt:     "1700007200000",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sent *sarama.ProducerMessage
+			producer := mocks.NewSyncProducer(t, nil)
+			producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+				sent = msg
+				return nil
+			})
+			p := NewDelayProducer(producer)
+			p.now = func() time.Time {
+				return now
+			}
+			err := p.SendMessage(&sarama.ProducerMessage{
+				Topic:   "order",
+				Key:     sarama.StringEncoder("1"),
+				Value:   sarama.StringEncoder("hello"),
+				Headers: []sarama.RecordHeader{recordHeader("trace-id", "abc")},
+			}, tc.deliverAt)
+			require.NoError(t, err)
+			require.NoError(t, producer.Close())
+			assert.Equal(t, tc.wantTopic, sent.Topic)
+			assert.Equal(t, tc.wantHeaders, producerHeaders(sent))
+			key, _ := sent.Key.Encode()
+			assert.Equal(t, []byte("1"), key)
+		})
+	}
+}
+
+func TestDelayForwarder_ConsumeClaim(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	delayMsg := func(offset int64, deliverAt, dueAt time.Duration) *sarama.ConsumerMessage {
+		return &sarama.ConsumerMessage{
+			Offset: offset,
+			Key:    []byte("1"),
+			Value:  []byte("hello"),
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte("trace-id"), Value: []byte("abc")},
+				{Key: []byte(HeaderDelayTarget), Value: []byte("order")},
+				{Key: []byte(HeaderDelayDeliverAt), Value: []byte(strconv.FormatInt(start.Add(deliverAt).UnixMilli(), 10))},
+				{Key: []byte(HeaderDelayDueAt), Value: []byte(strconv.FormatInt(start.Add(dueAt).UnixMilli(), 10))},
+			},
+		}
+	}
+	testCases := []struct {
+		name    string
+		msgs    []*sarama.ConsumerMessage
+		ctx     context.Context
+		sendErr error
+
+		wantErr    error
+		wantSleeps []time.Duration
+		wantPauses int
+		wantSent   []map[string]string
+		wantMarked []int64
+	}{
+		{
+			name: "等待到期之后转发",
+			msgs: []*sarama.ConsumerMessage{
+				// 在 delay.5s 里面等待 5s 之后，还剩 2s，进入 delay.1s
+				delayMsg(10, time.Second*7, time.Second*5),
+				// 已经到期，还剩 500ms，原地等待之后投递
+				delayMsg(11, time.Millisecond*5500, time.Second*5),
+			},
+			wantSleeps: []time.Duration{time.Second * 5, time.Millisecond * 500},
+			wantPauses: 1,
+			wantSent: []map[string]string{
+				{
+					"topic":              "delay.1s",
+					"trace-id":           "abc",
+					HeaderDelayTarget:    "order",
+					HeaderDelayDeliverAt: "1700000007000",
+					HeaderDelayDueAt:     "1700000006000",
+				},
+				{
+					"topic":    "order",
+					"trace-id": "abc",
+				},
+			},
+			wantMarked: []int64{10, 11},
+		},
+		{
+			name:       "转发失败，不提交",
+			msgs:       []*sarama.ConsumerMessage{delayMsg(10, 0, 0)},
+			sendErr:    sarama.ErrOutOfBrokers,
+			wantErr:    sarama.ErrOutOfBrokers,
+			wantSleeps: []time.Duration{},
+			wantSent:   []map[string]string{{"topic": "order", "trace-id": "abc"}},
+		},
+		{
+			name: "等待的时候重新分配分区",
+			msgs: []*sarama.ConsumerMessage{delayMsg(10, time.Second*7, time.Second*5)},
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			}(),
+			wantErr:    context.Canceled,
+			wantSleeps: []time.Duration{time.Second * 5},
+			wantPauses: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sent []map[string]string
+			producer := mocks.NewSyncProducer(t, nil)
+			for range tc.wantSent {
+				checker := func(msg *sarama.ProducerMessage) error {
+					headers := producerHeaders(msg)
+					headers["topic"] = msg.Topic
+					sent = append(sent, headers)
+					return nil
+				}
+				if tc.sendErr != nil {
+					producer.ExpectSendMessageWithMessageCheckerFunctionAndFail(checker, tc.sendErr)
+				} else {
+					producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(checker)
+				}
+			}
+			pauser := &mockPauser{}
+			f := NewDelayForwarder(producer, pauser, loggertest.NewLogger(), time.Second, time.Second*5)
+			now := start
+			f.now = func() time.Time {
+				return now
+			}
+			sleeps := []time.Duration{}
+			f.sleep = func(ctx context.Context, d time.Duration) bool {
+				sleeps = append(sleeps, d)
+				now = now.Add(d)
+				return ctx.Err() == nil
+			}
+			session := &mockSession{ctx: tc.ctx}
+
+			err := f.ConsumeClaim(session, newMockClaim("delay.5s", 2, tc.msgs...))
+			assert.ErrorIs(t, err, tc.wantErr)
+			require.NoError(t, producer.Close())
+			assert.Equal(t, tc.wantSleeps, sleeps)
+			assert.Equal(t, tc.wantPauses, pauser.paused)
+			assert.Equal(t, tc.wantPauses, pauser.resumed)
+			assert.Equal(t, tc.wantSent, sent)
+			assert.Equal(t, tc.wantMarked, session.offsets())
+		})
+	}
+}
+
+func TestDelayTopics(t *testing.T) {
+	assert.Equal(t, []string{
+		"delay.1s", "delay.5s", "delay.10s", "delay.30s",
+		"delay.1m", "delay.5m", "delay.10m", "delay.30m",
+		"delay.1h", "delay.2h",
+	}, DelayTopics(DefaultDelayLevels))
+}
+
+type mockPauser struct {
+	paused  int
+	resumed int
+}
+
+func (p *mockPauser) Pause(partitions map[string][]int32) {
+	p.paused++
+}
+
+func (p *mockPauser) Resume(partitions map[string][]int32) {
+	p.resumed++
+}
+
+func producerHeaders(msg *sarama.ProducerMessage) map[string]string {
+	res := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		res[string(h.Key)] = string(h.Value)
+	}
+	return res
+}
@@ -0,0 +1,162 @@
+package saramax
+
+import (
+	"context"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/IBM/sarama"
+)
+
+// DeliveryFunc 投递结果的回调，err 为 nil 表示投递成功
+type DeliveryFunc[T any] func(evt T, msg *sarama.ProducerMessage, err error)
+
+// Producer 往固定的 topic 发送 T 类型的消息
+// 同步模式下 Produce 会等待 broker 确认，异步模式下 Produce 只是把消息放进发送队列，
+// 投递结果通过 OnDelivery 设置的回调通知
+type Producer[T any] struct {
+	topic string
+	sync  sarama.SyncProducer
+	async sarama.AsyncProducer
+	l     logger.Logger
+
+	codec      Codec
+	key        func(evt T) string
+	headers    []func(ctx context.Context, evt T) []sarama.RecordHeader
+	onDelivery DeliveryFunc[T]
+
+	done chan struct{}
+}
+
+// NewSyncProducer 同步发送
+func NewSyncProducer[T any](topic string, p sarama.SyncProducer) *Producer[T] {
+	return &Producer[T]{
+		topic: topic,
+		sync:  p,
+		codec: JSONCodec{},
+	}
+}
+
+// NewAsyncProducer 异步发送
+// 想要拿到投递成功的回调，需要开启 Producer.Return.Successes
+// 没有设置 OnDelivery 的时候，投递失败只会记录日志
+func NewAsyncProducer[T any](topic string, p sarama.AsyncProducer, l logger.Logger) *Producer[T] {
+	res := &Producer[T]{
+		topic: topic,
+		async: p,
+		l:     l.Named("saramax"),
+		codec: JSONCodec{},
+		done:  make(chan struct{}),
+	}
+	go res.deliveryLoop()
+	return res
+}
+
+// Codec 设置消息体的编码方式，默认是 JSON
+func (p *Producer[T]) Codec(codec Codec) *Producer[T] {
+	p.codec = codec
+	return p
+}
+
+// Key 设置消息的 key，同一个 key 的消息会发到同一个分区
+func (p *Producer[T]) Key(fn func(evt T) string) *Producer[T] {
+	p.key = fn
+	return p
+}
+
+// Header 追加消息头，可以调用多次，按照调用的顺序追加
+func (p *Producer[T]) Header(fn func(ctx context.Context, evt T) []sarama.RecordHeader) *Producer[T] {
+	p.headers = append(p.headers, fn)
+	return p
+}
+
+// OnDelivery 设置投递结果的回调
+// 异步模式下回调在后台的 goroutine 里面执行，不要在回调里面阻塞太久
+func (p *Producer[T]) OnDelivery(fn DeliveryFunc[T]) *Producer[T] {
+	p.onDelivery = fn
+	return p
+}
+
+// Produce 编码失败的时候直接返回 error，不会发送
+func (p *Producer[T]) Produce(ctx context.Context, evt T) error {
+	msg, err := p.message(ctx, evt)
+	if err != nil {
+		return err
+	}
+	if p.async == nil {
+		_, _, err = p.sync.SendMessage(msg)
+		if p.onDelivery != nil {
+			p.onDelivery(evt, msg, err)
+		}
+		return err
+	}
+	select {
+	case p.async.Input() <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close 关闭底层的 producer
+// 异步模式下会等待还在发送队列里面的消息都有了投递结果之后才返回
+func (p *Producer[T]) Close() error {
+	if p.async == nil {
+		return p.sync.Close()
+	}
+	p.async.AsyncClose()
+	<-p.done
+	return nil
+}
+
+func (p *Producer[T]) message(ctx context.Context, evt T) (*sarama.ProducerMessage, error) {
+	val, headers, err := encode(p.codec, evt)
+	if err != nil {
+		return nil, err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:   p.topic,
+		Value:   sarama.ByteEncoder(val),
+		Headers: headers,
+		// 异步模式下用来在回调里面拿到原始的 evt
+		Metadata: evt,
+	}
+	if p.key != nil {
+		msg.Key = sarama.StringEncoder(p.key(evt))
+	}
+	for _, fn := range p.headers {
+		msg.Headers = append(msg.Headers, fn(ctx, evt)...)
+	}
+	return msg, nil
+}
+
+// deliveryLoop 一直运行到底层的 producer 关闭
+func (p *Producer[T]) deliveryLoop() {
+	defer close(p.done)
+	successes, errs := p.async.Successes(), p.async.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			p.report(msg, nil)
+		case perr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			p.report(perr.Msg, perr.Err)
+		}
+	}
+}
+
+func (p *Producer[T]) report(msg *sarama.ProducerMessage, err error) {
+	if p.onDelivery == nil {
+		if err != nil {
+			p.l.Error("发送消息失败", logger.String("topic", msg.Topic), logger.Error(err))
+		}
+		return
+	}
+	evt, _ := msg.Metadata.(T)
+	p.onDelivery(evt, msg, err)
+}
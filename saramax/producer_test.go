@@ -0,0 +1,147 @@
+package saramax
+
+import (
+	"context"
+	"errors"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/DaHuangQwQ/gpkg/logger/loggertest"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func TestProducer_Sync(t *testing.T) {
+	testCases := []struct {
+		name    string
+		codec   Codec
+		sendErr error
+
+		wantErr      error
+		wantSent     bool
+		wantDelivery error
+	}{
+		{
+			name:     "发送成功",
+			codec:    JSONCodec{},
+			wantSent: true,
+		},
+		{
+			name:         "发送失败",
+			codec:        JSONCodec{},
+			sendErr:      sarama.ErrOutOfBrokers,
+			wantErr:      sarama.ErrOutOfBrokers,
+			wantSent:     true,
+			wantDelivery: sarama.ErrOutOfBrokers,
+		},
+		{
+			name:    "编码失败",
+			codec:   errCodec{},
+			wantErr: errMockCodec,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sent *sarama.ProducerMessage
+			producer := mocks.NewSyncProducer(t, nil)
+			if tc.wantSent {
+				checker := func(msg *sarama.ProducerMessage) error {
+					sent = msg
+					return nil
+				}
+				if tc.sendErr != nil {
+					producer.ExpectSendMessageWithMessageCheckerFunctionAndFail(checker, tc.sendErr)
+				} else {
+					producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(checker)
+				}
+			}
+			var delivered []error
+			p := NewSyncProducer[event]("user", producer).
+				Codec(tc.codec).
+				Key(func(evt event) string {
+					return strconv.FormatInt(evt.ID, 10)
+				}).
+				Header(func(ctx context.Context, evt event) []sarama.RecordHeader {
+					return []sarama.RecordHeader{recordHeader("biz", "user")}
+				}).
+				Header(func(ctx context.Context, evt event) []sarama.RecordHeader {
+					return []sarama.RecordHeader{recordHeader("trace-id", ctx.Value(traceIDKey{}).(string))}
+				}).
+				OnDelivery(func(evt event, msg *sarama.ProducerMessage, err error) {
+					assert.Equal(t, event{ID: 1}, evt)
+					delivered = append(delivered, err)
+				})
+			ctx := context.WithValue(context.Background(), traceIDKey{}, "abc")
+			err := p.Produce(ctx, event{ID: 1})
+			assert.ErrorIs(t, err, tc.wantErr)
+			require.NoError(t, p.Close())
+			if !tc.wantSent {
+				assert.Nil(t, delivered)
+				return
+			}
+			assert.Equal(t, []error{tc.wantDelivery}, delivered)
+			assert.Equal(t, "user", sent.Topic)
+			key, _ := sent.Key.Encode()
+			assert.Equal(t, []byte("1"), key)
+			val, _ := sent.Value.Encode()
+			assert.JSONEq(t, `{"id":1}`, string(val))
+			assert.Equal(t, []sarama.RecordHeader{
+				recordHeader("biz", "user"),
+				recordHeader("trace-id", "abc"),
+			}, sent.Headers)
+		})
+	}
+}
+
+func TestProducer_Async(t *testing.T) {
+	cfg := mocks.NewTestConfig()
+	cfg.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, cfg)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	producer.ExpectInputAndSucceed()
+
+	results := make(map[int64]error)
+	p := NewAsyncProducer[event]("user", producer, loggertest.NewLogger()).
+		OnDelivery(func(evt event, msg *sarama.ProducerMessage, err error) {
+			results[evt.ID] = err
+		})
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, p.Produce(context.Background(), event{ID: i}))
+	}
+	// Close 之后所有的回调都已经执行完毕
+	require.NoError(t, p.Close())
+	assert.Equal(t, map[int64]error{
+		1: nil,
+		2: sarama.ErrOutOfBrokers,
+		3: nil,
+	}, results)
+}
+
+func TestProducer_AsyncWithoutCallback(t *testing.T) {
+	producer := mocks.NewAsyncProducer(t, nil)
+	producer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	l := loggertest.NewLogger()
+	p := NewAsyncProducer[event]("user", producer, l)
+	require.NoError(t, p.Produce(context.Background(), event{ID: 1}))
+	require.NoError(t, p.Close())
+	l.AssertLogged(t, logger.ErrorLevel, "发送消息失败",
+		logger.String("topic", "user"),
+		logger.Error(sarama.ErrOutOfBrokers))
+}
+
+type traceIDKey struct{}
+
+var errMockCodec = errors.New("mock codec error")
+
+type errCodec struct{}
+
+func (errCodec) Marshal(v any) ([]byte, error) {
+	return nil, errMockCodec
+}
+
+func (errCodec) Unmarshal(data []byte, v any) error {
+	return errMockCodec
+}
@@ -0,0 +1,215 @@
+package saramax
+
+import (
+	"context"
+	"fmt"
+	"github.com/IBM/sarama"
+	"strconv"
+	"time"
+)
+
+// 转发到重试 topic 和死信 topic 的时候，用 header 记录失败的信息
+const (
+	// HeaderRetryAttempt 已经进入过几次重试 topic
+	HeaderRetryAttempt = "x-retry-attempt"
+	// HeaderRetryAt 什么时候可以重试，unix 毫秒
+	HeaderRetryAt = "x-retry-at"
+	// HeaderOriginalTopic 第一次消费失败时候的 topic、partition 和 offset
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	// HeaderError 最后一次失败的错误信息
+	HeaderError = "x-error"
+	// HeaderFailedAt 最后一次失败的时间，RFC3339
+	HeaderFailedAt = "x-failed-at"
+)
+
+// RetryPolicy 消费失败之后，先原地重试，再依次转发到各个重试 topic，最后进入死信 topic
+type RetryPolicy struct {
+	// Backoff 原地重试的间隔，长度就是原地重试的次数
+	Backoff []time.Duration
+	// Tiers 重试 topic 的延迟，例如 5s、1m 对应 topic.retry.5s、topic.retry.1m
+	// 要用同一个 Handler 消费 RetryTopics 返回的 topic
+	Tiers []time.Duration
+	// DeadLetterTopic 为空的时候使用 topic.dlq
+	DeadLetterTopic string
+}
+
+// RetryTopics 需要额外订阅的重试 topic
+func (p RetryPolicy) RetryTopics(topic string) []string {
+	res := make([]string, 0, len(p.Tiers))
+	for _, tier := range p.Tiers {
+		res = append(res, RetryTopic(topic, tier))
+	}
+	return res
+}
+
+func (p RetryPolicy) deadLetterTopic(topic string) string {
+	if p.DeadLetterTopic != "" {
+		return p.DeadLetterTopic
+	}
+	return topic + ".dlq"
+}
+
+// RetryTopic 例如 user.retry.5s、user.retry.1m
+func RetryTopic(topic string, delay time.Duration) string {
+	return topic + ".retry." + formatDelay(delay)
+}
+
+func formatDelay(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d >= time.Minute && d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	case d >= time.Second && d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	default:
+		return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+	}
+}
+
+// retrier 负责原地重试，以及转发到重试 topic 和死信 topic
+type retrier struct {
+	policy RetryPolicy
+	// 为 nil 的时候只原地重试
+	producer sarama.SyncProducer
+	now      func() time.Time
+}
+
+// do 原地重试，ctx 结束的时候返回最后一次的错误
+func (r *retrier) do(ctx context.Context, fn func() error) error {
+	err := fn()
+	for _, backoff := range r.policy.Backoff {
+		if err == nil {
+			return nil
+		}
+		if !sleep(ctx, backoff) {
+			return err
+		}
+		err = fn()
+	}
+	return err
+}
+
+// wait 从重试 topic 消费的消息要等到 HeaderRetryAt 才能处理
+// 同一个重试 topic 的延迟都一样，所以阻塞整个 partition 也不影响后面的消息
+func (r *retrier) wait(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	val, ok := header(msg, HeaderRetryAt)
+	if !ok {
+		return true
+	}
+	retryAt, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return true
+	}
+	return sleep(ctx, time.UnixMilli(retryAt).Sub(r.now()))
+}
+
+// park 转发到下一个重试 topic，重试 topic 用完之后转发到死信 topic
+// 返回转发到的 topic
+func (r *retrier) park(msg *sarama.ConsumerMessage, cause error) (string, error) {
+	attempt := 0
+	if val, ok := header(msg, HeaderRetryAttempt); ok {
+		attempt, _ = strconv.Atoi(val)
+	}
+	if attempt < len(r.policy.Tiers) {
+		return r.forward(msg, cause, attempt+1, r.policy.Tiers[attempt])
+	}
+	return r.deadLetter(msg, cause)
+}
+
+// deadLetter 直接转发到死信 topic，例如反序列化失败，重试也没有意义
+func (r *retrier) deadLetter(msg *sarama.ConsumerMessage, cause error) (string, error) {
+	return r.forward(msg, cause, -1, 0)
+}
+
+// forward attempt 小于 0 的时候转发到死信 topic
+func (r *retrier) forward(msg *sarama.ConsumerMessage, cause error, attempt int, delay time.Duration) (string, error) {
+	if r.producer == nil {
+		return "", fmt.Errorf("saramax: 没有设置 producer，无法转发消息")
+	}
+	origin := originalTopic(msg)
+	now := r.now()
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+6)
+	for _, h := range msg.Headers {
+		if h != nil && !isRetryHeader(string(h.Key)) {
+			headers = append(headers, *h)
+		}
+	}
+	headers = append(headers,
+		recordHeader(HeaderOriginalTopic, origin),
+		recordHeader(HeaderOriginalPartition, originalValue(msg, HeaderOriginalPartition, strconv.FormatInt(int64(msg.Partition), 10))),
+		recordHeader(HeaderOriginalOffset, originalValue(msg, HeaderOriginalOffset, strconv.FormatInt(msg.Offset, 10))),
+		recordHeader(HeaderError, cause.Error()),
+		recordHeader(HeaderFailedAt, now.Format(time.RFC3339)),
+	)
+	topic := r.policy.deadLetterTopic(origin)
+	if attempt >= 0 {
+		topic = RetryTopic(origin, delay)
+		headers = append(headers,
+			recordHeader(HeaderRetryAttempt, strconv.Itoa(attempt)),
+			recordHeader(HeaderRetryAt, strconv.FormatInt(now.Add(delay).UnixMilli(), 10)))
+	} else if val, ok := header(msg, HeaderRetryAttempt); ok {
+		headers = append(headers, recordHeader(HeaderRetryAttempt, val))
+	}
+	pm := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		pm.Key = sarama.ByteEncoder(msg.Key)
+	}
+	_, _, err := r.producer.SendMessage(pm)
+	return topic, err
+}
+
+func isRetryHeader(key string) bool {
+	switch key {
+	case HeaderRetryAttempt, HeaderRetryAt, HeaderOriginalTopic, HeaderOriginalPartition,
+		HeaderOriginalOffset, HeaderError, HeaderFailedAt:
+		return true
+	default:
+		return false
+	}
+}
+
+func originalTopic(msg *sarama.ConsumerMessage) string {
+	return originalValue(msg, HeaderOriginalTopic, msg.Topic)
+}
+
+func originalValue(msg *sarama.ConsumerMessage, key string, def string) string {
+	if val, ok := header(msg, key); ok {
+		return val
+	}
+	return def
+}
+
+func header(msg *sarama.ConsumerMessage, key string) (string, bool) {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+func recordHeader(key, val string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(key), Value: []byte(val)}
+}
+
+// sleep ctx 结束的时候返回 false
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
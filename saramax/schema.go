@@ -0,0 +1,171 @@
+package saramax
+
+import (
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"strconv"
+	"sync"
+)
+
+// HeaderSchemaID 写消息时使用的 schema ID
+const HeaderSchemaID = "x-schema-id"
+
+var (
+	ErrSchemaNotFound  = errors.New("saramax: schema 不存在")
+	ErrSchemaIDMissing = errors.New("saramax: 消息头里面没有 schema ID")
+)
+
+// Schema 某个 subject 的一个版本
+type Schema struct {
+	// ID 和 Version 由 SchemaRegistry 分配
+	ID      int32
+	Version int
+	Subject string
+	// Definition schema 的定义，比如 proto 文件或者 JSON schema
+	// 本地的实现只用它来判断是不是同一个版本，不做兼容性校验
+	Definition string
+	// Codec 这个版本的消息体的编解码方式，为 nil 的时候使用 JSON
+	Codec Codec
+}
+
+type SchemaRegistry interface {
+	// Register 注册一个新版本，Definition 和已有的版本一样的时候返回已有的版本
+	Register(schema Schema) (Schema, error)
+	// Schema 按照 ID 查找，找不到返回 ErrSchemaNotFound
+	Schema(id int32) (Schema, error)
+	// Latest subject 的最新版本，找不到返回 ErrSchemaNotFound
+	Latest(subject string) (Schema, error)
+}
+
+// MemorySchemaRegistry 本地的 SchemaRegistry，用于测试或者单机部署
+// ID 全局递增，Version 在同一个 subject 里面从 1 开始递增
+type MemorySchemaRegistry struct {
+	mutex    sync.RWMutex
+	schemas  map[int32]Schema
+	subjects map[string][]int32
+	nextID   int32
+}
+
+func NewMemorySchemaRegistry() *MemorySchemaRegistry {
+	return &MemorySchemaRegistry{
+		schemas:  make(map[int32]Schema),
+		subjects: make(map[string][]int32),
+	}
+}
+
+func (r *MemorySchemaRegistry) Register(schema Schema) (Schema, error) {
+	if schema.Subject == "" {
+		return Schema{}, errors.New("saramax: schema 的 subject 不能为空")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ids := r.subjects[schema.Subject]
+	for _, id := range ids {
+		if r.schemas[id].Definition == schema.Definition {
+			return r.schemas[id], nil
+		}
+	}
+	r.nextID++
+	schema.ID = r.nextID
+	schema.Version = len(ids) + 1
+	r.schemas[schema.ID] = schema
+	r.subjects[schema.Subject] = append(ids, schema.ID)
+	return schema, nil
+}
+
+func (r *MemorySchemaRegistry) Schema(id int32) (Schema, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	schema, ok := r.schemas[id]
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: id %d", ErrSchemaNotFound, id)
+	}
+	return schema, nil
+}
+
+func (r *MemorySchemaRegistry) Latest(subject string) (Schema, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	ids := r.subjects[subject]
+	if len(ids) == 0 {
+		return Schema{}, fmt.Errorf("%w: subject %s", ErrSchemaNotFound, subject)
+	}
+	return r.schemas[ids[len(ids)-1]], nil
+}
+
+// SchemaCodec 带版本的消息体，schema ID 放在 HeaderSchemaID 消息头里面
+// 写消息的时候使用 subject 的最新版本，读消息的时候按照消息头里面的 schema ID
+// 找到写入时的版本，再用那个版本的 Codec 解码，所以升级 schema 之后旧的消息依旧可以消费
+// 新旧版本之间的字段兼容由各个版本的 Codec 自己保证，比如 JSON 和 protobuf 都会忽略未知字段
+type SchemaCodec struct {
+	registry SchemaRegistry
+	subject  string
+}
+
+func NewSchemaCodec(registry SchemaRegistry, subject string) *SchemaCodec {
+	return &SchemaCodec{registry: registry, subject: subject}
+}
+
+// Marshal 不会写 schema ID，只有通过 Producer 发送的时候才会带上消息头
+func (c *SchemaCodec) Marshal(v any) ([]byte, error) {
+	data, _, err := c.MarshalWithHeaders(v)
+	return data, err
+}
+
+// Unmarshal 没有 schema ID，按照最新的版本解码
+func (c *SchemaCodec) Unmarshal(data []byte, v any) error {
+	schema, err := c.registry.Latest(c.subject)
+	if err != nil {
+		return err
+	}
+	return schemaCodec(schema).Unmarshal(data, v)
+}
+
+func (c *SchemaCodec) MarshalWithHeaders(v any) ([]byte, []sarama.RecordHeader, error) {
+	schema, err := c.registry.Latest(c.subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := schemaCodec(schema).Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, []sarama.RecordHeader{
+		recordHeader(HeaderSchemaID, strconv.FormatInt(int64(schema.ID), 10)),
+	}, nil
+}
+
+func (c *SchemaCodec) UnmarshalWithHeaders(data []byte, headers []*sarama.RecordHeader, v any) error {
+	var (
+		val string
+		ok  bool
+	)
+	for _, h := range headers {
+		if h != nil && string(h.Key) == HeaderSchemaID {
+			val, ok = string(h.Value), true
+		}
+	}
+	if !ok {
+		return ErrSchemaIDMissing
+	}
+	id, err := strconv.ParseInt(val, 10, 32)
+	if err != nil {
+		return fmt.Errorf("saramax: 非法的 schema ID %s", val)
+	}
+	schema, err := c.registry.Schema(int32(id))
+	if err != nil {
+		return err
+	}
+	if schema.Subject != c.subject {
+		return fmt.Errorf("saramax: schema %d 属于 %s，不是 %s", id, schema.Subject, c.subject)
+	}
+	return schemaCodec(schema).Unmarshal(data, v)
+}
+
+func schemaCodec(schema Schema) Codec {
+	if schema.Codec == nil {
+		return JSONCodec{}
+	}
+	return schema.Codec
+}
@@ -0,0 +1,156 @@
+package saramax
+
+import (
+	"context"
+	"github.com/DaHuangQwQ/gpkg/logger"
+	"github.com/DaHuangQwQ/gpkg/logger/loggertest"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"testing"
+)
+
+func TestMemorySchemaRegistry(t *testing.T) {
+	r := NewMemorySchemaRegistry()
+	_, err := r.Latest("user")
+	assert.ErrorIs(t, err, ErrSchemaNotFound)
+	_, err = r.Register(Schema{Definition: "v1"})
+	assert.Error(t, err)
+
+	v1, err := r.Register(Schema{Subject: "user", Definition: "v1"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), v1.ID)
+	assert.Equal(t, 1, v1.Version)
+	order, err := r.Register(Schema{Subject: "order", Definition: "v1"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), order.ID)
+	assert.Equal(t, 1, order.Version)
+	v2, err := r.Register(Schema{Subject: "user", Definition: "v2"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), v2.ID)
+	assert.Equal(t, 2, v2.Version)
+
+	// 重复注册返回已有的版本
+	again, err := r.Register(Schema{Subject: "user", Definition: "v1"})
+	require.NoError(t, err)
+	assert.Equal(t, v1, again)
+
+	latest, err := r.Latest("user")
+	require.NoError(t, err)
+	assert.Equal(t, v2, latest)
+	schema, err := r.Schema(1)
+	require.NoError(t, err)
+	assert.Equal(t, v1, schema)
+	_, err = r.Schema(100)
+	assert.ErrorIs(t, err, ErrSchemaNotFound)
+}
+
+type userV1 struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type userV2 struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Nickname string `json:"nickname"`
+}
+
+func TestSchemaCodec(t *testing.T) {
+	r := NewMemorySchemaRegistry()
+	_, err := r.Register(Schema{Subject: "user", Definition: "v1"})
+	require.NoError(t, err)
+	_, err = r.Register(Schema{Subject: "order", Definition: "v1"})
+	require.NoError(t, err)
+
+	// 用 v1 写入的消息
+	var v1Msgs []*sarama.ConsumerMessage
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		v1Msgs = append(v1Msgs, toConsumerMessage(msg, 10))
+		return nil
+	})
+	p := NewSyncProducer[userV1]("user", producer).Codec(NewSchemaCodec(r, "user"))
+	require.NoError(t, p.Produce(context.Background(), userV1{ID: 1, Name: "Tom"}))
+	require.NoError(t, p.Close())
+	assert.Equal(t, []*sarama.RecordHeader{{Key: []byte(HeaderSchemaID), Value: []byte("1")}}, v1Msgs[0].Headers)
+
+	// 升级到 v2 之后，旧的消息依旧可以消费
+	_, err = r.Register(Schema{Subject: "user", Definition: "v2"})
+	require.NoError(t, err)
+	l := loggertest.NewLogger()
+	var handled []userV2
+	// 解码失败的消息都进入死信 topic
+	var dead []string
+	dlq := mocks.NewSyncProducer(t, nil)
+	for i := 0; i < 3; i++ {
+		dlq.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			dead = append(dead, msg.Topic)
+			return nil
+		})
+	}
+	h := NewHandler[userV2](l, func(msg *sarama.ConsumerMessage, evt userV2) error {
+		handled = append(handled, evt)
+		return nil
+	}).Codec(NewSchemaCodec(r, "user")).Retry(dlq, RetryPolicy{})
+	session := &mockSession{}
+	require.NoError(t, h.ConsumeClaim(session, newMockClaim("user", 0,
+		v1Msgs[0],
+		&sarama.ConsumerMessage{Offset: 11, Value: []byte(`{"id":2}`)},
+		&sarama.ConsumerMessage{Offset: 12, Value: []byte(`{"id":3}`),
+			Headers: []*sarama.RecordHeader{{Key: []byte(HeaderSchemaID), Value: []byte("2")}}},
+		&sarama.ConsumerMessage{Offset: 13, Value: []byte(`{"id":4}`),
+			Headers: []*sarama.RecordHeader{{Key: []byte(HeaderSchemaID), Value: []byte("100")}}},
+		&sarama.ConsumerMessage{Offset: 14, Value: []byte(`{"id":5,"nickname":"Jerry"}`),
+			Headers: []*sarama.RecordHeader{{Key: []byte(HeaderSchemaID), Value: []byte("3")}}},
+	)))
+	assert.Equal(t, []userV2{{ID: 1, Name: "Tom"}, {ID: 5, Nickname: "Jerry"}}, handled)
+	require.NoError(t, dlq.Close())
+	assert.Equal(t, []string{"user.dlq", "user.dlq", "user.dlq"}, dead)
+
+	testCases := []struct {
+		offset  int64
+		wantErr string
+	}{
+		{offset: 11, wantErr: ErrSchemaIDMissing.Error()},
+		{offset: 12, wantErr: "saramax: schema 2 属于 order，不是 user"},
+		{offset: 13, wantErr: "saramax: schema 不存在: id 100"},
+	}
+	for _, tc := range testCases {
+		entries := l.Filter(func(e loggertest.Entry) bool {
+			return e.Message == "反序列消息体失败" && e.Has(logger.Int64("offset", tc.offset))
+		})
+		require.Len(t, entries, 1, tc.offset)
+		errVal, _ := entries[0].Value("error")
+		assert.EqualError(t, errVal.(error), tc.wantErr)
+	}
+}
+
+func TestSchemaCodec_Proto(t *testing.T) {
+	r := NewMemorySchemaRegistry()
+	_, err := r.Register(Schema{Subject: "user", Definition: "StringValue", Codec: ProtoCodec{}})
+	require.NoError(t, err)
+	codec := NewSchemaCodec(r, "user")
+	data, headers, err := codec.MarshalWithHeaders(wrapperspb.String("hello"))
+	require.NoError(t, err)
+	msg := toConsumerMessage(&sarama.ProducerMessage{Value: sarama.ByteEncoder(data), Headers: headers}, 0)
+
+	var res *wrapperspb.StringValue
+	require.NoError(t, decode(codec, msg, &res))
+	assert.Equal(t, "hello", res.GetValue())
+	// 没有消息头的时候按照最新的版本解码
+	res = nil
+	require.NoError(t, codec.Unmarshal(data, &res))
+	assert.Equal(t, "hello", res.GetValue())
+}
+
+func toConsumerMessage(msg *sarama.ProducerMessage, offset int64) *sarama.ConsumerMessage {
+	res := &sarama.ConsumerMessage{Topic: msg.Topic, Offset: offset}
+	res.Value, _ = msg.Value.Encode()
+	for _, h := range msg.Headers {
+		res.Headers = append(res.Headers, &sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return res
+}
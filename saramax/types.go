@@ -8,4 +8,5 @@ type Consumer interface {
 
 type HandlerFunc[T any] func(msg *sarama.ConsumerMessage, event T) error
 
+// BatchHandlerFunc 部分消息处理失败的时候返回 *BatchError
 type BatchHandlerFunc[T any] func(msg []*sarama.ConsumerMessage, event []T) error
@@ -3,11 +3,22 @@ package weapp
 import (
 	"github.com/DaHuangQwQ/gpkg/ginx"
 	"github.com/DaHuangQwQ/gpkg/grpcx"
+	"github.com/DaHuangQwQ/gpkg/logger"
 	"github.com/DaHuangQwQ/gpkg/saramax"
+	"net/http"
 )
 
 type App struct {
 	GRPCServer *grpcx.Server
 	WebServer  *ginx.Server
 	Consumers  []saramax.Consumer
+	// Levels 不为 nil 的时候，可以通过管理接口在运行时修改日志级别
+	Levels *logger.LevelRegistry
+}
+
+// RegisterAdminRoutes 把管理接口挂载到 mux 上，一般是只对内网开放的端口
+func (app *App) RegisterAdminRoutes(mux *http.ServeMux) {
+	if app.Levels != nil {
+		mux.Handle("/admin/log/level", app.Levels)
+	}
 }